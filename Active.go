@@ -0,0 +1,165 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// A torrent the current user is seeding or leeching right now
+type ActivePeerEntry struct {
+	TorrentId int
+	Name      string
+	Seeding   bool
+	// bytes per second
+	UploadRate   uint64
+	DownloadRate uint64
+	Connected    time.Duration
+}
+
+// Get the torrents the current user is seeding or leeching right now,
+// as listed on the own user details page
+func ActiveTorrents(c *Connection) ([]ActivePeerEntry, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.get(c.buildUrl("userdetails.php", url.Values{"id": {fmt.Sprintf("%d", c.GetCookies().Uid)}}))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	debugRequest(resp, string(body))
+
+	return parseActiveTorrents(bytes.NewReader(body))
+}
+
+type ActiveFilter int
+
+const (
+	ActiveAll ActiveFilter = iota
+	ActiveSeeding
+	ActiveLeeching
+)
+
+// Filter the entries of ActiveTorrents to the seeding or leeching ones
+func FilterActiveTorrents(entries []ActivePeerEntry, filter ActiveFilter) []ActivePeerEntry {
+	if filter == ActiveAll {
+		return entries
+	}
+
+	filtered := make([]ActivePeerEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Seeding == (filter == ActiveSeeding) {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	return filtered
+}
+
+// Get the summed up current upload and download rates (bytes per second) of the entries
+func ActiveRates(entries []ActivePeerEntry) (upload, download uint64) {
+	for _, entry := range entries {
+		upload += entry.UploadRate
+		download += entry.DownloadRate
+	}
+
+	return upload, download
+}
+
+// Parse the tables of the seeding and leeching torrents. The tables are recognized by
+// their header (name and rate columns), seeding or leeching by the title of the surrounding block.
+func parseActiveTorrents(reader io.Reader) ([]ActivePeerEntry, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ActivePeerEntry, 0)
+	ire, _ := regexp.Compile("details\\.php\\?id=(\\d+)")
+
+	doc.Find("table.tableinborder").Each(func(i int, table *goquery.Selection) {
+		columns := make(map[string]int)
+		table.Find("tr").First().Find("td, th").Each(func(col int, td *goquery.Selection) {
+			label := strings.ToLower(strings.TrimSpace(td.Text()))
+			switch {
+			case strings.HasPrefix(label, "name"):
+				columns["name"] = col
+			case strings.Contains(label, "rate") && (strings.Contains(label, "up") || strings.Contains(label, "ul")):
+				columns["ulrate"] = col
+			case strings.Contains(label, "rate") && (strings.Contains(label, "down") || strings.Contains(label, "dl")):
+				columns["dlrate"] = col
+			case strings.Contains(label, "verbunden") || strings.Contains(label, "connected"):
+				columns["connected"] = col
+			}
+		})
+		_, hasName := columns["name"]
+		_, hasUlRate := columns["ulrate"]
+		if !hasName || !hasUlRate {
+			return
+		}
+
+		title := strings.ToLower(table.Closest("div.blockinborder").Find("div.centeredtitle").First().Text())
+		seeding := strings.Contains(title, "seed")
+
+		table.Find("tr").Each(func(row int, tr *goquery.Selection) {
+			if row == 0 {
+				return
+			}
+			tds := tr.Find("td")
+			link := tds.Eq(columns["name"]).Find("a[href*=details]").First()
+			href, _ := link.Attr("href")
+			m := ire.FindStringSubmatch(href)
+			if m == nil {
+				return
+			}
+			id, err := strconv.ParseInt(m[1], 10, 32)
+			if err != nil {
+				return
+			}
+
+			entry := ActivePeerEntry{TorrentId: int(id), Seeding: seeding}
+			entry.Name = strings.TrimSpace(link.AttrOr("title", link.Text()))
+			entry.UploadRate = stringToDatasize(strings.TrimSuffix(strings.TrimSpace(tds.Eq(columns["ulrate"]).Text()), "/s"))
+			if col, ok := columns["dlrate"]; ok {
+				entry.DownloadRate = stringToDatasize(strings.TrimSuffix(strings.TrimSpace(tds.Eq(col).Text()), "/s"))
+			}
+			if col, ok := columns["connected"]; ok {
+				entry.Connected = time.Duration(parseConnected(tds.Eq(col).Text())) * time.Second
+			}
+
+			entries = append(entries, entry)
+		})
+	})
+
+	return entries, nil
+}
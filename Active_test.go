@@ -0,0 +1,88 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestActiveTorrents(t *testing.T) {
+	page := readFixture(t, "userdetails_active.html")
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/userdetails.php" || r.URL.Query().Get("id") != "42" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(page)
+	}))
+
+	entries, err := ActiveTorrents(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ActivePeerEntry{
+		{205, "Some.Movie.2018.German.1080p", true, 1572864, 0, 51*time.Hour + 4*time.Minute + 5*time.Second},
+		{180, "Other.Show.S01E01", true, 524288, 0, 4*time.Minute + 5*time.Second},
+		{510, "Big.Release.2018", false, 10240, 2359296, time.Hour},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, entry := range entries {
+		if entry != want[i] {
+			t.Errorf("entry %d: got %+v, want %+v", i, entry, want[i])
+		}
+	}
+}
+
+func TestFilterActiveTorrents(t *testing.T) {
+	entries, err := parseActiveTorrents(bytes.NewReader(readFixture(t, "userdetails_active.html")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for filter, want := range map[ActiveFilter]string{ActiveAll: "[205 180 510]", ActiveSeeding: "[205 180]", ActiveLeeching: "[510]"} {
+		ids := make([]int, 0)
+		for _, entry := range FilterActiveTorrents(entries, filter) {
+			ids = append(ids, entry.TorrentId)
+		}
+		if fmt.Sprint(ids) != want {
+			t.Errorf("filter %d: got torrents %v, want %s", filter, ids, want)
+		}
+	}
+
+	tests := []struct {
+		filter           ActiveFilter
+		upload, download uint64
+	}{
+		// 1,50 MB + 512 KB + 10 KB up, 2,25 MB down
+		{ActiveAll, 2107392, 2359296},
+		{ActiveSeeding, 2097152, 0},
+		{ActiveLeeching, 10240, 2359296},
+	}
+	for _, test := range tests {
+		if up, down := ActiveRates(FilterActiveTorrents(entries, test.filter)); up != test.upload || down != test.download {
+			t.Errorf("filter %d: got rates %d/%d, want %d/%d", test.filter, up, down, test.upload, test.download)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sync"
+)
+
+// Get the torrents bookmarked by the current user, crawling all pages of the bookmark list
+func Bookmarks(c *Connection) ([]TorrentEntry, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	return bookmarks(context.Background(), c)
+}
+
+func bookmarks(ctx context.Context, c *Connection) ([]TorrentEntry, error) {
+	list := make([]TorrentEntry, 0)
+	for page, maxpage := int64(0), int64(0); page <= maxpage; page++ {
+		data := url.Values{}
+		if page > 0 {
+			data.Set("page", fmt.Sprintf("%d", page))
+		}
+		entries, last, err := fetchTorrentList(ctx, c, c.buildUrl("bookmarks.php", data))
+		if err != nil {
+			return nil, err
+		}
+		maxpage = last
+		list = append(list, entries...)
+	}
+
+	return list, nil
+}
+
+// Bookmark a torrent
+func Bookmark(c *Connection, torrentId int64) (bool, error) {
+	if err := c.assureLogin(); err != nil {
+		return false, err
+	}
+
+	bookmarkUrl := c.buildUrl("bookmark.php", url.Values{"torrent": {fmt.Sprintf("%d", torrentId)}})
+	if c.dryRun {
+		req, err := c.newRequest("GET", bookmarkUrl, nil)
+		if err != nil {
+			return false, err
+		}
+		dryRunResponse(req)
+		return true, nil
+	}
+
+	resp, err := c.get(bookmarkUrl)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return false, newRequestError(resp, ErrTorrentNotFound)
+	}
+	if ok, err := parseAjaxResult(body); !ok {
+		return false, newRequestError(resp, err)
+	}
+
+	return true, nil
+}
+
+// Remove the bookmark of a torrent
+func Unbookmark(c *Connection, torrentId int64) (bool, error) {
+	if err := c.assureLogin(); err != nil {
+		return false, err
+	}
+
+	return unbookmark(context.Background(), c, torrentId)
+}
+
+func unbookmark(ctx context.Context, c *Connection, torrentId int64) (bool, error) {
+	data := url.Values{"delbookmark[]": {fmt.Sprintf("%d", torrentId)}}
+	resp, err := c.postFormContext(ctx, c.buildUrl("takedelbookmark.php", nil), data)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if c.dryRun {
+		return true, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return false, newRequestError(resp, ErrTorrentNotFound)
+	}
+	if ok, err := parseAjaxResult(body); !ok {
+		return false, newRequestError(resp, err)
+	}
+	if resp.StatusCode >= 400 {
+		return false, newRequestError(resp, errors.New("removing the bookmark failed"))
+	}
+
+	return true, nil
+}
+
+// Remove the bookmarks of many torrents, at most SetMaxConcurrency at a time.
+// The bookmark list is read first, torrents which are not bookmarked count as removed.
+// Failed torrents are reported in the error map (which has no entries for the others),
+// the error is only set if the bookmark list could not be read.
+// If ctx is cancelled, the remaining torrents fail with the context error.
+func UnbookmarkMany(ctx context.Context, c *Connection, ids []int64) (map[int64]error, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	list, err := bookmarks(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	bookmarked := make(map[int64]bool, len(list))
+	for _, entry := range list {
+		bookmarked[int64(entry.Id)] = true
+	}
+
+	errs := make(map[int64]error)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan bool, c.getMaxConcurrency())
+
+	for _, id := range ids {
+		if !bookmarked[id] {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			mutex.Lock()
+			errs[id] = ctx.Err()
+			mutex.Unlock()
+			continue
+		case semaphore <- true:
+		}
+
+		wg.Add(1)
+		go func(id int64) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			if _, err := unbookmark(ctx, c, id); err != nil {
+				mutex.Lock()
+				errs[id] = err
+				mutex.Unlock()
+			}
+		}(id)
+	}
+
+	wg.Wait()
+
+	return errs, nil
+}
@@ -0,0 +1,96 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Serve the two pages of the bookmark list and record the removed bookmarks.
+// Removing one of the failing ids answers with 404.
+func bookmarkHandler(t *testing.T, removed *[]int64, failing map[int64]bool, mutex *sync.Mutex) http.Handler {
+	pages := [][]byte{readFixture(t, "bookmarks_0.html"), readFixture(t, "bookmarks_1.html")}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bookmarks.php":
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if page >= len(pages) {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+			w.Write(pages[page])
+		case "/takedelbookmark.php":
+			r.ParseForm()
+			id, _ := strconv.ParseInt(r.PostForm.Get("delbookmark[]"), 10, 64)
+			if failing[id] {
+				http.NotFound(w, r)
+				return
+			}
+			mutex.Lock()
+			*removed = append(*removed, id)
+			mutex.Unlock()
+			http.Redirect(w, r, "/bookmarks.php", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func TestBookmarks(t *testing.T) {
+	var mutex sync.Mutex
+	c := newTestConnection(t, bookmarkHandler(t, nil, nil, &mutex))
+
+	entries, err := Bookmarks(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, 0)
+	for _, entry := range entries {
+		ids = append(ids, entry.Id)
+	}
+	if len(ids) != 3 || ids[0] != 205 || ids[1] != 204 || ids[2] != 180 {
+		t.Errorf("bookmarked ids = %v, want [205 204 180]", ids)
+	}
+}
+
+func TestUnbookmarkMany(t *testing.T) {
+	var mutex sync.Mutex
+	removed := make([]int64, 0)
+	c := newTestConnection(t, bookmarkHandler(t, &removed, map[int64]bool{204: true}, &mutex))
+	c.SetMaxConcurrency(2)
+
+	// 999 is not bookmarked
+	errs, err := UnbookmarkMany(context.Background(), c, []int64{205, 204, 180, 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[204], ErrTorrentNotFound) {
+		t.Errorf("errs = %v, want only ErrTorrentNotFound for 204", errs)
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+	if len(removed) != 2 || removed[0] != 180 || removed[1] != 205 {
+		t.Errorf("removed = %v, want [180 205]", removed)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+
+package irrenhaus_api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+)
+
+// pages whose GET responses may be cached
+var cacheablePages = map[string]bool{
+	"details.php":      true,
+	"browse.php":       true,
+	"viewsnatches.php": true,
+}
+
+type responseCache struct {
+	sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]cacheEntry
+	// the current time, time.Now if nil
+	clock func() time.Time
+}
+
+type cacheEntry struct {
+	status     string
+	statusCode int
+	header     http.Header
+	body       []byte
+	stored     time.Time
+}
+
+// Enable an in-memory cache for the GET requests of details.php, browse.php and viewsnatches.php.
+// Responses are kept for ttl, the oldest entry is dropped when maxEntries is reached.
+// Cached pages may be stale, e.g. seeder and leecher counts can be outdated for up to ttl.
+// After ttl the page is requested again, conditionally if the site sent an ETag or Last-Modified header.
+// A "304 Not Modified" answer renews the cached page.
+// A ttl <= 0 disables the cache.
+func (c *Connection) SetCache(ttl time.Duration, maxEntries int) {
+	if ttl <= 0 || maxEntries <= 0 {
+		c.cache = nil
+		return
+	}
+	c.cache = &responseCache{ttl: ttl, maxEntries: maxEntries, entries: make(map[string]cacheEntry)}
+}
+
+// Remove a single URL from the cache. The URL may be absolute or relative to the base url.
+func (c *Connection) InvalidateCache(rawurl string) {
+	if c.cache == nil {
+		return
+	}
+	if u, err := url.Parse(rawurl); err == nil && !u.IsAbs() {
+		rawurl = c.buildUrl(rawurl, nil)
+	}
+	c.cache.Lock()
+	delete(c.cache.entries, rawurl)
+	c.cache.Unlock()
+}
+
+// Remove all entries from the cache
+func (c *Connection) ClearCache() {
+	if c.cache == nil {
+		return
+	}
+	c.cache.Lock()
+	c.cache.entries = make(map[string]cacheEntry)
+	c.cache.Unlock()
+}
+
+func (rc *responseCache) now() time.Time {
+	if rc.clock == nil {
+		return time.Now()
+	}
+	return rc.clock()
+}
+
+func isCacheable(req *http.Request) bool {
+	return req.Method == "GET" && cacheablePages[path.Base(req.URL.Path)]
+}
+
+func (rc *responseCache) lookup(req *http.Request) *http.Response {
+	rc.Lock()
+	defer rc.Unlock()
+
+	key := req.URL.String()
+	entry, ok := rc.entries[key]
+	if !ok {
+		return nil
+	}
+	if rc.now().Sub(entry.stored) > rc.ttl {
+		if entry.header.Get("ETag") == "" && entry.header.Get("Last-Modified") == "" {
+			delete(rc.entries, key)
+		}
+		return nil
+	}
+
+	return entry.response(req)
+}
+
+// Make the request conditional if there is an expired entry with validators
+func (rc *responseCache) addValidators(req *http.Request) {
+	rc.Lock()
+	defer rc.Unlock()
+
+	entry, ok := rc.entries[req.URL.String()]
+	if !ok {
+		return
+	}
+	if etag := entry.header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if modified := entry.header.Get("Last-Modified"); modified != "" {
+		req.Header.Set("If-Modified-Since", modified)
+	}
+}
+
+// Renew the entry after a "304 Not Modified" response and return the cached page
+func (rc *responseCache) revalidate(req *http.Request) *http.Response {
+	rc.Lock()
+	defer rc.Unlock()
+
+	key := req.URL.String()
+	entry, ok := rc.entries[key]
+	if !ok {
+		return nil
+	}
+	entry.stored = rc.now()
+	rc.entries[key] = entry
+
+	return entry.response(req)
+}
+
+func (entry cacheEntry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     entry.status,
+		StatusCode: entry.statusCode,
+		Header:     entry.header,
+		Body:       ioutil.NopCloser(bytes.NewReader(entry.body)),
+		Request:    req,
+	}
+}
+
+// Store the response and replace its body with a fresh reader
+func (rc *responseCache) store(resp *http.Response) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	rc.Lock()
+	defer rc.Unlock()
+
+	if _, ok := rc.entries[resp.Request.URL.String()]; !ok && len(rc.entries) >= rc.maxEntries {
+		var oldestKey string
+		var oldest time.Time
+		for key, entry := range rc.entries {
+			if oldestKey == "" || entry.stored.Before(oldest) {
+				oldestKey, oldest = key, entry.stored
+			}
+		}
+		delete(rc.entries, oldestKey)
+	}
+
+	rc.entries[resp.Request.URL.String()] = cacheEntry{
+		status:     resp.Status,
+		statusCode: resp.StatusCode,
+		header:     resp.Header,
+		body:       body,
+		stored:     rc.now(),
+	}
+
+	return nil
+}
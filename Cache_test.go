@@ -0,0 +1,206 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Serve the details fixture with an ETag and count the requests by URL
+func cacheHandler(t *testing.T, requests map[string]int, mutex *sync.Mutex) http.Handler {
+	details := readFixture(t, "details.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests[r.URL.RequestURI()]++
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Header().Set("ETag", `"205-1"`)
+		if r.Header.Get("If-None-Match") == `"205-1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(details)
+	})
+}
+
+// Let the cache of c use a clock that only moves on advance
+func fakeCacheClock(c *Connection) (advance func(time.Duration)) {
+	now := time.Date(2018, 3, 12, 20, 15, 0, 0, time.UTC)
+	c.cache.clock = func() time.Time { return now }
+
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCacheHit(t *testing.T) {
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+	c := newTestConnection(t, cacheHandler(t, requests, &mutex))
+	c.SetCache(time.Minute, 10)
+
+	for i := 0; i < 2; i++ {
+		if _, err := Details(c, 205, false, false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(requests) != 1 {
+		t.Fatalf("requests = %v, want a single details page", requests)
+	}
+	var uri string
+	for uri = range requests {
+		if requests[uri] != 1 {
+			t.Errorf("%s requested %d times, want 1", uri, requests[uri])
+		}
+	}
+
+	c.InvalidateCache(uri)
+	if _, err := Details(c, 205, false, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if requests[uri] != 2 {
+		t.Errorf("%s requested %d times after InvalidateCache, want 2", uri, requests[uri])
+	}
+
+	c.ClearCache()
+	if _, err := Details(c, 205, false, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if requests[uri] != 3 {
+		t.Errorf("%s requested %d times after ClearCache, want 3", uri, requests[uri])
+	}
+}
+
+func TestCacheRevalidate(t *testing.T) {
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+	c := newTestConnection(t, cacheHandler(t, requests, &mutex))
+	c.SetCache(time.Minute, 10)
+	advance := fakeCacheClock(c)
+
+	if _, err := Details(c, 205, false, false, false); err != nil {
+		t.Fatal(err)
+	}
+	advance(2 * time.Minute)
+	// the expired page is requested again, the 304 answer is served from the cache
+	te, err := Details(c, 205, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if te.Name != "Newest.Release.2018" {
+		t.Errorf("Name = %q after revalidating, want the cached page", te.Name)
+	}
+	for uri, n := range requests {
+		if n != 2 {
+			t.Errorf("%s requested %d times, want 2", uri, n)
+		}
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	requests := 0
+	details := readFixture(t, "details.html")
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(details)
+	}))
+	c.SetCache(time.Minute, 10)
+	advance := fakeCacheClock(c)
+
+	// without validators the page is dropped once it expired
+	for _, step := range []struct {
+		after    time.Duration
+		requests int
+	}{{0, 1}, {30 * time.Second, 1}, {30 * time.Second, 1}, {time.Second, 2}, {59 * time.Second, 2}} {
+		advance(step.after)
+		if _, err := Details(c, 205, false, false, false); err != nil {
+			t.Fatal(err)
+		}
+		if requests != step.requests {
+			t.Errorf("after %v: %d requests, want %d", step.after, requests, step.requests)
+		}
+	}
+}
+
+func TestCacheOnlyGet(t *testing.T) {
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+	c := newTestConnection(t, cacheHandler(t, requests, &mutex))
+	c.SetCache(time.Minute, 10)
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Do("POST", "details.php", nil, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if requests["/details.php"] != 2 {
+		t.Errorf("POST sent %d times, want 2", requests["/details.php"])
+	}
+}
+
+func TestSearchNotModified(t *testing.T) {
+	var mutex sync.Mutex
+	browse := readFixture(t, "browse.html")
+	conditional := 0
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("Last-Modified", "Mon, 12 Mar 2018 20:15:00 GMT")
+		if r.Header.Get("If-Modified-Since") == "Mon, 12 Mar 2018 20:15:00 GMT" {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(browse)
+	}))
+	c.SetCache(time.Minute, 10)
+	advance := fakeCacheClock(c)
+
+	// every request after the first one finds the page expired
+	first, err := Search(c, "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	advance(2 * time.Minute)
+	hash, err := SearchPageHash(c, "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	advance(2 * time.Minute)
+
+	second, err := Search(c, "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) == 0 || len(second) != len(first) {
+		t.Errorf("got %d results after the 304, want the %d cached ones", len(second), len(first))
+	}
+	advance(2 * time.Minute)
+	if again, err := SearchPageHash(c, "", nil, false); err != nil || again != hash {
+		t.Errorf("SearchPageHash = %q, %v after the 304, want %q", again, err, hash)
+	}
+	// the second search revalidates all three pages
+	if conditional != 5 {
+		t.Errorf("%d conditional requests answered with 304, want 5", conditional)
+	}
+}
@@ -20,11 +20,17 @@ package Category
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
 )
 
 var categories map[int]string
+var categoriesMutex sync.RWMutex
 
 func initCategories() {
+	categoriesMutex.Lock()
+	defer categoriesMutex.Unlock()
 	if len(categories) > 0 {
 		return
 	}
@@ -59,8 +65,29 @@ func initCategories() {
 	categories[28] = "3-D"
 }
 
+// Replace the known categories, e.g. with the ones currently listed by the site
+func Load(newCategories map[int]string) {
+	categoriesMutex.Lock()
+	defer categoriesMutex.Unlock()
+
+	categories = make(map[int]string, len(newCategories))
+	for id, name := range newCategories {
+		categories[id] = name
+	}
+}
+
+// Clear the known categories, the next lookup starts over with the default categories
+func Reset() {
+	categoriesMutex.Lock()
+	defer categoriesMutex.Unlock()
+
+	categories = nil
+}
+
 func ToInt(name string) (int, error) {
 	initCategories()
+	categoriesMutex.RLock()
+	defer categoriesMutex.RUnlock()
 	for id, val := range categories {
 		if val == name {
 			return id, nil
@@ -72,6 +99,8 @@ func ToInt(name string) (int, error) {
 
 func ToString(id int) (string, error) {
 	initCategories()
+	categoriesMutex.RLock()
+	defer categoriesMutex.RUnlock()
 	if val, ok := categories[id]; ok {
 		return val, nil
 	}
@@ -81,6 +110,65 @@ func ToString(id int) (string, error) {
 
 func GetCategories() map[int]string {
 	initCategories()
+	categoriesMutex.RLock()
+	defer categoriesMutex.RUnlock()
+
+	// return a copy, the map may be replaced by Load
+	list := make(map[int]string, len(categories))
+	for id, name := range categories {
+		list[id] = name
+	}
+
+	return list
+}
+
+// Resolve the names of several categories.
+// Unknown ids are left empty in the result and listed in the returned error.
+func ToStrings(ids []int) ([]string, error) {
+	initCategories()
+	categoriesMutex.RLock()
+	defer categoriesMutex.RUnlock()
+
+	names := make([]string, len(ids))
+	unknown := make([]string, 0)
+	for i, id := range ids {
+		if val, ok := categories[id]; ok {
+			names[i] = val
+		} else {
+			unknown = append(unknown, fmt.Sprintf("%d", id))
+		}
+	}
+	if len(unknown) > 0 {
+		return names, errors.New("category ids not found: " + strings.Join(unknown, ", "))
+	}
+
+	return names, nil
+}
+
+// Resolve the ids of several categories.
+// Unknown names are left 0 in the result and listed in the returned error.
+func ToInts(names []string) ([]int, error) {
+	initCategories()
+	categoriesMutex.RLock()
+	defer categoriesMutex.RUnlock()
+
+	byName := make(map[string]int, len(categories))
+	for id, val := range categories {
+		byName[val] = id
+	}
+
+	ids := make([]int, len(names))
+	unknown := make([]string, 0)
+	for i, name := range names {
+		if id, ok := byName[name]; ok {
+			ids[i] = id
+		} else {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return ids, errors.New("category names not found: " + strings.Join(unknown, ", "))
+	}
 
-	return categories
+	return ids, nil
 }
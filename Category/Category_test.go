@@ -0,0 +1,75 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+
+package Category
+
+import "testing"
+
+func TestResetLoad(t *testing.T) {
+	t.Cleanup(Reset)
+
+	Load(map[int]string{1: "Hörbücher", 29: "UHD"})
+	if name, err := ToString(29); err != nil || name != "UHD" {
+		t.Errorf("ToString(29) = %q, %v, want UHD", name, err)
+	}
+	if _, err := ToString(17); err == nil {
+		t.Error("ToString(17) found a category which is not loaded")
+	}
+
+	// Load replaces the categories, it does not merge them
+	Load(map[int]string{30: "Retro"})
+	if id, err := ToInt("Retro"); err != nil || id != 30 {
+		t.Errorf("ToInt(Retro) = %d, %v, want 30", id, err)
+	}
+	if _, err := ToString(29); err == nil {
+		t.Error("ToString(29) still finds the previous category set")
+	}
+
+	Reset()
+	if name, err := ToString(17); err != nil || name != "1080p" {
+		t.Errorf("after Reset ToString(17) = %q, %v, want the default 1080p", name, err)
+	}
+	if len(GetCategories()) != 28 {
+		t.Errorf("after Reset %d categories, want the 28 defaults", len(GetCategories()))
+	}
+}
+
+func TestToStringsToInts(t *testing.T) {
+	t.Cleanup(Reset)
+	Reset()
+
+	names, err := ToStrings([]int{17, 99, 28, 0})
+	if err == nil || err.Error() != "category ids not found: 99, 0" {
+		t.Errorf("err = %v, want the unknown ids 99 and 0", err)
+	}
+	if len(names) != 4 || names[0] != "1080p" || names[1] != "" || names[2] != "3-D" || names[3] != "" {
+		t.Errorf("names = %q, want the known names in place", names)
+	}
+
+	ids, err := ToInts([]string{"720p", "Blu-ray", "XXX"})
+	if err == nil || err.Error() != "category names not found: Blu-ray" {
+		t.Errorf("err = %v, want the unknown name Blu-ray", err)
+	}
+	if len(ids) != 3 || ids[0] != 18 || ids[1] != 0 || ids[2] != 21 {
+		t.Errorf("ids = %v, want [18 0 21]", ids)
+	}
+
+	if names, err := ToStrings([]int{1, 2}); err != nil || len(names) != 2 {
+		t.Errorf("ToStrings of known ids = %q, %v", names, err)
+	}
+}
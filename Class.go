@@ -0,0 +1,70 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+
+package irrenhaus_api
+
+import (
+	"errors"
+	"strings"
+)
+
+// User class (rank) on the site, see UserProfile.Rank. The classes are ordered, so they can be compared:
+// User < Power User < VIP < Uploader < Moderator < Administrator < SysOp
+type Class int
+
+const (
+	ClassUnknown Class = iota
+	ClassUser
+	ClassPowerUser
+	ClassVIP
+	ClassUploader
+	ClassModerator
+	ClassAdministrator
+	ClassSysOp
+)
+
+var classNames = map[Class]string{
+	ClassUser:          "User",
+	ClassPowerUser:     "Power User",
+	ClassVIP:           "VIP",
+	ClassUploader:      "Uploader",
+	ClassModerator:     "Moderator",
+	ClassAdministrator: "Administrator",
+	ClassSysOp:         "SysOp",
+}
+
+// Parse the class name as shown on the site. Unknown names set ClassUnknown and return an error.
+func (c *Class) Parse(s string) error {
+	s = strings.TrimSpace(s)
+	for class, name := range classNames {
+		if strings.EqualFold(name, s) {
+			*c = class
+			return nil
+		}
+	}
+
+	*c = ClassUnknown
+	return errors.New("unknown class " + s)
+}
+
+func (c Class) String() string {
+	if name, ok := classNames[c]; ok {
+		return name
+	}
+	return "Unknown"
+}
@@ -0,0 +1,35 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"testing"
+)
+
+func TestClassParse(t *testing.T) {
+	var class Class
+	if err := class.Parse(" power user "); err != nil || class != ClassPowerUser {
+		t.Errorf("Parse = %v, %v, want %v", class, err, ClassPowerUser)
+	}
+	if err := class.Parse("Ehrenmitglied"); err == nil || class != ClassUnknown {
+		t.Errorf("Parse of an unknown class = %v, %v, want an error", class, err)
+	}
+	if !(ClassUser < ClassPowerUser && ClassVIP < ClassModerator && ClassAdministrator < ClassSysOp) {
+		t.Error("the classes are not ordered")
+	}
+}
@@ -19,19 +19,381 @@
 package irrenhaus_api
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
 )
 
+type Comment struct {
+	Id          int64
+	TorrentId   int64
+	TorrentName string
+	User        string
+	UserId      int
+	Date        time.Time
+	Text        string
+}
+
+// Get all comments written by a user, crawling all pages of the comment history.
+// Returns ErrPermissionDenied if the history of the user is not visible to the current user
+// and ErrNotFound if the user does not exist.
+func UserComments(c *Connection, userId int64) ([]Comment, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	comments := make([]Comment, 0)
+	data := url.Values{"action": {"viewcomments"}, "id": {fmt.Sprintf("%d", userId)}}
+	for page := int64(0); ; page++ {
+		data.Set("page", fmt.Sprintf("%d", page))
+		body, err := fetchCommentPage(c, c.buildUrl("userhistory.php", data), ErrNotFound)
+		if err != nil {
+			return nil, err
+		}
+
+		pageComments, maxpage, err := parseComments(bytes.NewReader(body), c.url, c.getLocation())
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, pageComments...)
+
+		if page >= maxpage {
+			break
+		}
+	}
+
+	return comments, nil
+}
+
+// Get all comments of a torrent, crawling all pages (oldest first)
+func CommentList(c *Connection, torrentId int64) ([]Comment, error) {
+	comments := make([]Comment, 0)
+	for page := 0; ; page++ {
+		pageComments, hasMore, err := CommentListPage(c, torrentId, page)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, pageComments...)
+
+		if !hasMore {
+			break
+		}
+	}
+
+	return comments, nil
+}
+
+// Get a single page of the comments of a torrent, the first page is 0.
+// hasMore is true if there are further pages.
+func CommentListPage(c *Connection, torrentId int64, page int) ([]Comment, bool, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, false, err
+	}
+
+	comments, maxpage, err := commentPage(c, torrentId, int64(page))
+	if err != nil {
+		return nil, false, err
+	}
+
+	return comments, int64(page) < maxpage, nil
+}
+
+// Get a page of the comments of a torrent and the number of the last page
+func commentPage(c *Connection, torrentId int64, page int64) ([]Comment, int64, error) {
+	data := url.Values{"id": {fmt.Sprintf("%d", torrentId)}}
+	if page > 0 {
+		data.Set("page", fmt.Sprintf("%d", page))
+	}
+	body, err := fetchCommentPage(c, c.buildUrl("details.php", data), ErrTorrentNotFound)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	comments, maxpage, err := parseComments(bytes.NewReader(body), c.url, c.getLocation())
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range comments {
+		if comments[i].TorrentId == 0 {
+			comments[i].TorrentId = torrentId
+		}
+	}
+
+	return comments, maxpage, nil
+}
+
+func fetchCommentPage(c *Connection, pageUrl string, notFound error) ([]byte, error) {
+	resp, err := c.get(pageUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return nil, newRequestError(resp, notFound)
+	}
+	if bytes.Contains(body, []byte("Zugriff verweigert")) || bytes.Contains(body, []byte("keine Berechtigung")) {
+		return nil, newRequestError(resp, ErrPermissionDenied)
+	}
+
+	return body, nil
+}
+
+// Parse a page of comments. Every comment is a table with a header row
+// ("#123 von User am 2018-01-02 15:04:05 - Torrent: Name") and the text in the second row.
+// Returns the comments and the highest page number linked by the pager, links in the comments don't count.
+func parseComments(reader io.Reader, baseUrl string, location *time.Location) ([]Comment, int64, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	comments := make([]Comment, 0)
+	dre, _ := regexp.Compile("\\d{4}-\\d{2}-\\d{2} \\d{2}:\\d{2}:\\d{2}")
+	ire, _ := regexp.Compile("details\\.php\\?id=(\\d+)")
+
+	doc.Find("table.tableinborder").Each(func(i int, s *goquery.Selection) {
+		anchor := s.Find("a[name^=comm]").First()
+		if len(anchor.Nodes) == 0 {
+			return
+		}
+
+		comment := Comment{}
+		name, _ := anchor.Attr("name")
+		comment.Id, _ = strconv.ParseInt(strings.TrimPrefix(name, "comm"), 10, 64)
+
+		trs := s.Find("tr")
+		head := trs.Eq(0)
+		if len(head.Find("a[href*=userdetails]").Nodes) > 0 {
+			comment.User, comment.UserId = parseUserLink(head)
+		}
+		date, err := time.ParseInLocation("2006-01-02 15:04:05", dre.FindString(head.Text()), location)
+		if err == nil {
+			comment.Date = date
+		}
+		link := head.Find("a[href^='details.php']").First()
+		if href, ok := link.Attr("href"); ok && ire.MatchString(href) {
+			comment.TorrentId, _ = strconv.ParseInt(ire.FindStringSubmatch(href)[1], 10, 64)
+			comment.TorrentName = strings.TrimSpace(link.Text())
+		}
+
+		// the text cell may contain the table of a quote
+		rawText, err := trs.Eq(1).Children().Filter("td").Last().Html()
+		if err == nil {
+			comment.Text = StripDescription(rawText, baseUrl)
+		}
+
+		comments = append(comments, comment)
+	})
+
+	maxpage := int64(0)
+	pre, _ := regexp.Compile("page=(\\d+)")
+	doc.Find("p[align=center] a[href*=page]").Not("table.tableinborder a").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		for _, m := range pre.FindAllStringSubmatch(href, -1) {
+			page, _ := strconv.ParseInt(m[1], 10, 32)
+			if page > maxpage {
+				maxpage = page
+			}
+		}
+	})
+
+	return comments, maxpage, nil
+}
+
 func CommentWrite(c *Connection, id int64, message string) (bool, error) {
-	c.assureLogin()
+	if err := c.assureLogin(); err != nil {
+		return false, err
+	}
+
+	if c.commentDedupWindow > 0 {
+		comment, err := recentComment(c, id, sameComment(message), c.now())
+		if err != nil {
+			return false, err
+		}
+		if comment != nil {
+			debugLog("[CommentWrite] comment already posted:", comment.Id)
+			return true, nil
+		}
+	}
 
 	data := url.Values{}
 	data.Add("tid", fmt.Sprintf("%d", id))
 	data.Add("text", message)
+
+	return commentAdd(c, data)
+}
+
+// Write a comment and return its id.
+// With the dedup window enabled the id of an identical recent comment is returned instead of posting again.
+// In dry-run mode nothing is posted and 0 is returned.
+func CommentWriteID(c *Connection, id int64, message string) (int64, error) {
+	if _, err := CommentWrite(c, id, message); err != nil {
+		return 0, err
+	}
+	if c.dryRun {
+		return 0, nil
+	}
+
+	// the site doesn't return the id, so look up the newest matching comment
+	comment, err := recentComment(c, id, sameComment(message), time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	if comment == nil {
+		return 0, errors.New("comment not found after posting")
+	}
+
+	return comment.Id, nil
+}
+
+// Enable the duplicate check of CommentWrite: before posting, the newest comments of the torrent are read
+// and if the current user wrote the same text within the window, no new comment is posted.
+// This makes retrying a failed write safe. Disabled (0) by default.
+func (c *Connection) SetCommentDedupWindow(window time.Duration) {
+	c.commentDedupWindow = window
+}
+
+// Find the newest comment of the current user matching the sent message, written within the dedup window.
+// Only the last page of the comments is read, which holds the newest ones.
+// A zero now skips the window check.
+func recentComment(c *Connection, torrentId int64, matches func(text string) bool, now time.Time) (*Comment, error) {
+	comments, maxpage, err := commentPage(c, torrentId, 0)
+	if err != nil {
+		return nil, err
+	}
+	if maxpage > 0 {
+		comments, _, err = commentPage(c, torrentId, maxpage)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := len(comments) - 1; i >= 0; i-- {
+		comment := comments[i]
+		if int64(comment.UserId) != c.GetCookies().Uid || !matches(comment.Text) {
+			continue
+		}
+		if !now.IsZero() && now.Sub(comment.Date) > c.commentDedupWindow {
+			// older comments are outside the window as well
+			break
+		}
+
+		return &comment, nil
+	}
+
+	return nil, nil
+}
+
+// Compare the stripped comment text with the sent one, ignoring whitespace.
+// The sent text is BBCode, so it is stripped like the rendered comment.
+func sameComment(sent string) func(text string) bool {
+	return func(text string) bool {
+		return normalizeComment(text) == normalizeComment(stripBBCode(sent))
+	}
+}
+
+// Check if the stripped comment text ends with the sent one, ignoring whitespace.
+// Used for replies, the rendered quote in front of the reply differs from the sent BBCode.
+func endsWithComment(sent string) func(text string) bool {
+	return func(text string) bool {
+		return strings.HasSuffix(normalizeComment(text), normalizeComment(stripBBCode(sent)))
+	}
+}
+
+func normalizeComment(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Strip the BBCode of a message the same way StripDescription strips the rendered HTML:
+// links become "text [url]", images their url and the formatting is removed.
+func stripBBCode(message string) string {
+	lre, _ := regexp.Compile("(?is)\\[url=([^\\]]+)\\](.*?)\\[/url\\]")
+	stripped := lre.ReplaceAllString(message, "$2 [$1]")
+	ure, _ := regexp.Compile("(?is)\\[url\\](.*?)\\[/url\\]")
+	stripped = ure.ReplaceAllString(stripped, "$1 [$1]")
+	ire, _ := regexp.Compile("(?is)\\[img\\](.*?)\\[/img\\]")
+	stripped = ire.ReplaceAllString(stripped, "$1")
+	fre, _ := regexp.Compile("(?i)\\[/?(?:b|i|u|center|color|size|font)(?:=[^\\]]*)?\\]")
+
+	return fre.ReplaceAllString(stripped, "")
+}
+
+// Reply to a comment of a torrent and return the id of the reply.
+//
+// The site has no threaded comments, so like the "quote" button of the web interface
+// the reply is a new comment starting with the quoted parent comment.
+// Like CommentWriteID, the id is looked up in the comments after posting.
+// In dry-run mode nothing is posted and 0 is returned.
+func CommentReply(c *Connection, torrentId, parentCommentId int64, message string) (int64, error) {
+	if err := c.assureLogin(); err != nil {
+		return 0, err
+	}
+
+	resp, err := c.get(c.buildUrl("comment.php", url.Values{"action": {"quote"}, "cid": {fmt.Sprintf("%d", parentCommentId)}}))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return 0, newRequestError(resp, errors.New("comment not found"))
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	form := doc.Find("form").First()
+	quote := strings.TrimSpace(form.Find("textarea[name=text]").Text())
+
+	data := parseHiddenFields(form)
+	data.Set("tid", fmt.Sprintf("%d", torrentId))
+	text := message
+	if quote != "" {
+		text = quote + "\n" + message
+	}
+	data.Set("text", text)
+
+	if _, err := commentAdd(c, data); err != nil {
+		return 0, err
+	}
+	if c.dryRun {
+		return 0, nil
+	}
+
+	// the site doesn't return the id, so look up the newest comment ending with the reply
+	comment, err := recentComment(c, torrentId, endsWithComment(message), time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	if comment == nil {
+		return 0, errors.New("comment not found after posting")
+	}
+
+	return comment.Id, nil
+}
+
+func commentAdd(c *Connection, data url.Values) (bool, error) {
 	resp, err := c.postForm(c.buildUrl("comment.php", url.Values{"action": {"add"}}), data)
 	if err != nil {
 		return false, err
@@ -41,11 +403,11 @@ func CommentWrite(c *Connection, id int64, message string) (bool, error) {
 	debugRequest(resp, string(body))
 
 	if resp.StatusCode == 404 {
-		return false, errors.New("torrent not found")
+		return false, newRequestError(resp, ErrTorrentNotFound)
 	}
 
-	if strings.Contains(string(body), "<span>Fehler</span>") {
-		return false, errors.New("error at irrenhaus")
+	if ok, err := parseAjaxResult(body); !ok {
+		return false, newRequestError(resp, err)
 	}
 
 	return true, nil
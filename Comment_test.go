@@ -0,0 +1,278 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+
+package irrenhaus_api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+func TestCommentReply(t *testing.T) {
+	var mutex sync.Mutex
+	var posted url.Values
+	quote := readFixture(t, "comment_quote.html")
+	first := readFixture(t, "comments_first.html")
+	replied := readFixture(t, "comments_replied.html")
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		switch {
+		case r.URL.Path == "/comment.php" && r.URL.Query().Get("action") == "quote" && r.URL.Query().Get("cid") == "530":
+			w.Write(quote)
+		case r.URL.Path == "/comment.php" && r.URL.Query().Get("action") == "add":
+			r.ParseForm()
+			posted = r.PostForm
+		case r.URL.Path == "/details.php" && r.URL.Query().Get("page") == "":
+			w.Write(first)
+		case r.URL.Path == "/details.php" && r.URL.Query().Get("page") == "2":
+			w.Write(replied)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	id, err := CommentReply(c, 205, 530, "Gern geschehen, siehe [b]NFO[/b].")
+	if err != nil || id != 532 {
+		t.Errorf("CommentReply = %d, %v, want 532", id, err)
+	}
+	if want := "[quote=bob]Gerne.[/quote]\nGern geschehen, siehe [b]NFO[/b]."; posted.Get("text") != want {
+		t.Errorf("posted text %q, want %q", posted.Get("text"), want)
+	}
+	if posted.Get("token") != "c0ffee" || posted.Get("tid") != "205" {
+		t.Errorf("posted %v, want the hidden fields of the quote form", posted)
+	}
+
+	if _, err := CommentReply(c, 205, 999, "Hallo"); err == nil {
+		t.Error("replied to a missing comment")
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	page := transform.NewReader(bytes.NewReader(readFixture(t, "comments.html")), charmap.ISO8859_1.NewDecoder())
+	comments, maxpage, err := parseComments(page, "https://irrenhaus.dyndns.dk", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the link to page 8 in the second comment is no pager link
+	if maxpage != 2 {
+		t.Errorf("maxpage = %d, want 2", maxpage)
+	}
+	if len(comments) != 2 {
+		t.Fatalf("got %d comments, want 2", len(comments))
+	}
+	if comments[0].Id != 501 || comments[0].UserId != 10 || comments[0].User != "alice" || comments[0].Text != "Danke für den Upload!" {
+		t.Errorf("first comment = %+v", comments[0])
+	}
+	if comments[1].TorrentId != 0 {
+		t.Errorf("second comment has torrent id %d from the link in its text", comments[1].TorrentId)
+	}
+}
+
+func TestCommentListPage(t *testing.T) {
+	c := newTestConnection(t, pageHandler(readFixture(t, "comments.html")))
+
+	comments, hasMore, err := CommentListPage(c, 205, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the link to page 8 in the second comment is no pager link
+	if hasMore {
+		t.Error("hasMore on the last page of the pager")
+	}
+	if len(comments) != 2 {
+		t.Fatalf("got %d comments, want 2", len(comments))
+	}
+	if comments[0].Id != 501 || comments[0].UserId != 10 || comments[0].TorrentId != 205 || comments[0].Text != "Danke für den Upload!" {
+		t.Errorf("first comment = %+v", comments[0])
+	}
+
+	if _, hasMore, _ = CommentListPage(c, 205, 1); !hasMore {
+		t.Error("no more pages after the second page")
+	}
+}
+
+func TestUserCommentsNotFound(t *testing.T) {
+	c := newTestConnection(t, http.NotFoundHandler())
+
+	if _, err := UserComments(c, 4711); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UserComments: err = %v, want ErrNotFound", err)
+	}
+	if _, _, err := CommentListPage(c, 205, 0); !errors.Is(err, ErrTorrentNotFound) {
+		t.Errorf("CommentListPage: err = %v, want ErrTorrentNotFound", err)
+	}
+}
+
+func TestUserComments(t *testing.T) {
+	c := newTestConnection(t, pageHandler(readFixture(t, "usercomments.html")))
+
+	comments, err := UserComments(c, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 2 {
+		t.Fatalf("got %d comments, want 2", len(comments))
+	}
+	// the author is linked in the header as well
+	for i, want := range []int64{205, 204} {
+		if comments[i].TorrentId != want || comments[i].UserId != 10 {
+			t.Errorf("comment %d: torrent %d by user %d, want torrent %d by user 10", comments[i].Id, comments[i].TorrentId, comments[i].UserId, want)
+		}
+	}
+	if comments[0].TorrentName != "Newest.Release.2018" {
+		t.Errorf("TorrentName = %q", comments[0].TorrentName)
+	}
+}
+
+func TestCommentList(t *testing.T) {
+	pages := [][]byte{readFixture(t, "comments_page_0.html"), readFixture(t, "comments_page_1.html"), readFixture(t, "comments_page_2.html")}
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if r.URL.Path != "/details.php" || page >= len(pages) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(pages[page])
+	}))
+	c.SetLocation(time.UTC)
+
+	for page, want := range []bool{true, true, false} {
+		if _, hasMore, err := CommentListPage(c, 205, page); err != nil || hasMore != want {
+			t.Errorf("CommentListPage(%d): hasMore = %v, %v, want %v", page, hasMore, err, want)
+		}
+	}
+
+	comments, err := CommentList(c, 205)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int64, 0)
+	for _, comment := range comments {
+		ids = append(ids, comment.Id)
+	}
+	if fmt.Sprint(ids) != "[601 602 603 604 605]" {
+		t.Errorf("comment ids = %v, want all pages in order", ids)
+	}
+	if comments[1].Text != "Schöne Grüße" {
+		t.Errorf("text = %q, want the decoded ISO-8859-1 text", comments[1].Text)
+	}
+	if want := time.Date(2018, 3, 12, 22, 15, 0, 0, time.UTC); !comments[4].Date.Equal(want) {
+		t.Errorf("date = %v, want %v", comments[4].Date, want)
+	}
+}
+
+func TestCommentWriteRetry(t *testing.T) {
+	var mutex sync.Mutex
+	posts := 0
+	requested := make(map[string]int)
+	first := readFixture(t, "comments_first.html")
+	last := readFixture(t, "comments_last.html")
+	posted := readFixture(t, "comments_posted.html")
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		if r.URL.Path == "/comment.php" {
+			posts++
+			return
+		}
+		page := r.URL.Query().Get("page")
+		requested[page]++
+		switch {
+		case page == "":
+			w.Write(first)
+		case page == "2" && posts > 0:
+			w.Write(posted)
+		case page == "2":
+			w.Write(last)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	c.SetLocation(time.UTC)
+	c.SetCommentDedupWindow(5 * time.Minute)
+	c.clock = func() time.Time { return time.Date(2018, 3, 14, 12, 0, 0, 0, time.UTC) }
+
+	message := "Danke für den [b]Upload[/b], siehe [url=https://example.org/info]Info[/url]"
+	for i := 0; i < 2; i++ {
+		if ok, err := CommentWrite(c, 205, message); !ok || err != nil {
+			t.Fatalf("CommentWrite #%d = %v, %v", i+1, ok, err)
+		}
+	}
+	id, err := CommentWriteID(c, 205, message)
+	if err != nil || id != 531 {
+		t.Errorf("CommentWriteID = %d, %v, want 531", id, err)
+	}
+
+	if posts != 1 {
+		t.Errorf("posted %d times, want once", posts)
+	}
+	if requested["1"] != 0 {
+		t.Error("read the middle page of the comments")
+	}
+}
+
+func TestStripBBCode(t *testing.T) {
+	messages := map[string]string{
+		"Danke!":                                 "Danke!",
+		"[b]fett[/b] und [I]kursiv[/I]":          "fett und kursiv",
+		"[color=red][size=4]laut[/size][/color]": "laut",
+		"[url=https://example.org]Seite[/url]":   "Seite [https://example.org]",
+		"[url]https://example.org[/url]":         "https://example.org [https://example.org]",
+		"[img]https://example.org/a.png[/img]":   "https://example.org/a.png",
+		"[center]Zeile 1\nZeile 2[/center]":      "Zeile 1\nZeile 2",
+	}
+	for message, want := range messages {
+		if got := stripBBCode(message); got != want {
+			t.Errorf("stripBBCode(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
+
+func TestCommentWriteIDDryRun(t *testing.T) {
+	posts := 0
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			posts++
+		}
+		// no comment was posted, so none can be found
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(readFixture(t, "comments_first.html"))
+	}))
+	c.SetDryRun(true)
+
+	id, err := CommentWriteID(c, 205, "Danke!")
+	if err != nil || id != 0 {
+		t.Errorf("CommentWriteID = %d, %v, want 0 and no error", id, err)
+	}
+	if posts != 0 {
+		t.Errorf("posted %d times in dry-run mode", posts)
+	}
+}
@@ -0,0 +1,257 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// A confirmation step ("Bist du sicher?") the site puts in front of a destructive action.
+//
+// Operations going through a confirmation are CommentDelete (use CommentDeleteConfirmation
+// for the two-step variant) and ReportTorrent, if the site asks for one.
+// Submit the confirmation with Confirm.
+type Confirmation struct {
+	// the question shown by the site
+	Message string
+	// GET for a confirmation link, POST for a form
+	Method string
+	Url    string
+	// hidden fields (including the token) of the form
+	Data url.Values
+}
+
+// Submit the confirmation, in dry-run mode it is only logged
+func Confirm(c *Connection, conf *Confirmation) (bool, error) {
+	_, body, err := submitConfirmation(c, conf)
+	if err != nil {
+		return false, err
+	}
+
+	return parseAjaxResult(body)
+}
+
+// Submit the confirmation and return the response (with the body already read and closed).
+// In dry-run mode the response is nil.
+func submitConfirmation(c *Connection, conf *Confirmation) (*http.Response, []byte, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, nil, err
+	}
+	if conf == nil {
+		return nil, nil, errors.New("missing confirmation")
+	}
+
+	var resp *http.Response
+	var err error
+	if conf.Method == "POST" {
+		resp, err = c.postForm(conf.Url, conf.Data)
+	} else {
+		if c.dryRun {
+			req, err := c.newRequest("GET", conf.Url, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			dryRunResponse(req)
+			return nil, nil, nil
+		}
+		resp, err = c.get(conf.Url)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if c.dryRun && conf.Method == "POST" {
+		return nil, nil, nil
+	}
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return nil, nil, newRequestError(resp, errors.New("not found"))
+	}
+
+	return resp, body, nil
+}
+
+// Detect a confirmation page and extract the form or link confirming the action.
+// Returns nil if the page does not ask for a confirmation.
+func parseConfirmation(body []byte, pageUrl string) *Confirmation {
+	lower := bytes.ToLower(body)
+	if !bytes.Contains(lower, []byte("sicher")) && !bytes.Contains(lower, []byte("wirklich")) {
+		return nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	base, err := url.Parse(pageUrl)
+	if err != nil {
+		return nil
+	}
+
+	conf := &Confirmation{}
+	doc.Find("form").EachWithBreak(func(i int, form *goquery.Selection) bool {
+		if len(form.Find("input[name=sure]").Nodes) == 0 {
+			return true
+		}
+		action, _ := form.Attr("action")
+		ref, err := url.Parse(action)
+		if err != nil {
+			return true
+		}
+		method, _ := form.Attr("method")
+		conf.Method = strings.ToUpper(method)
+		if conf.Method != "POST" {
+			conf.Method = "GET"
+		}
+		conf.Url = base.ResolveReference(ref).String()
+		conf.Data = parseHiddenFields(form)
+		conf.Message = strings.TrimSpace(form.Text())
+		return false
+	})
+
+	if conf.Url == "" {
+		doc.Find("a[href*='sure=1']").EachWithBreak(func(i int, link *goquery.Selection) bool {
+			href, _ := link.Attr("href")
+			ref, err := url.Parse(href)
+			if err != nil {
+				return true
+			}
+			conf.Method = "GET"
+			conf.Url = base.ResolveReference(ref).String()
+			conf.Message = strings.TrimSpace(link.Parent().Text())
+			return false
+		})
+	}
+
+	if conf.Url == "" {
+		return nil
+	}
+	if conf.Method == "GET" && len(conf.Data) > 0 {
+		// a GET form sends its fields as query
+		u, _ := url.Parse(conf.Url)
+		query := u.Query()
+		for k, v := range conf.Data {
+			query[k] = v
+		}
+		u.RawQuery = query.Encode()
+		conf.Url = u.String()
+		conf.Data = nil
+	}
+
+	return conf
+}
+
+// Delete a comment, the confirmation of the site is submitted automatically.
+// In dry-run mode the requests are only logged and true is returned.
+func CommentDelete(c *Connection, commentId int64) (bool, error) {
+	conf, err := CommentDeleteConfirmation(c, commentId)
+	if err != nil {
+		return false, err
+	}
+	if conf == nil {
+		// deleted without confirmation (or dry-run)
+		return true, nil
+	}
+
+	resp, body, err := submitConfirmation(c, conf)
+	if err != nil {
+		return false, err
+	}
+	if resp == nil {
+		return true, nil
+	}
+	if ok, err := parseAjaxResult(body); !ok {
+		return false, newRequestError(resp, err)
+	}
+	if !commentDeleted(resp, body) {
+		return false, newRequestError(resp, errors.New("comment not deleted"))
+	}
+
+	return true, nil
+}
+
+// Request the deletion of a comment without confirming it.
+// Returns the confirmation to be passed to Confirm, or nil if the site deleted the comment right away.
+// In dry-run mode the request is only logged and nil is returned.
+func CommentDeleteConfirmation(c *Connection, commentId int64) (*Confirmation, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	deleteUrl := c.buildUrl("comment.php", url.Values{"action": {"delete"}, "cid": {fmt.Sprintf("%d", commentId)}})
+	if c.dryRun {
+		// the site may delete without asking, so the request is not sent
+		req, err := c.newRequest("GET", deleteUrl, nil)
+		if err != nil {
+			return nil, err
+		}
+		dryRunResponse(req)
+		return nil, nil
+	}
+
+	resp, err := c.get(deleteUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return nil, newRequestError(resp, errors.New("comment not found"))
+	}
+	if ok, err := parseAjaxResult(body); !ok {
+		return nil, newRequestError(resp, err)
+	}
+	if conf := parseConfirmation(body, deleteUrl); conf != nil {
+		return conf, nil
+	}
+	if !commentDeleted(resp, body) {
+		return nil, newRequestError(resp, errors.New("unexpected response to the deletion"))
+	}
+
+	return nil, nil
+}
+
+// After deleting a comment the site redirects back to the torrent (with a Location or Refresh header)
+// or shows a message that the comment was deleted
+func commentDeleted(resp *http.Response, body []byte) bool {
+	for _, header := range []string{"Location", "Refresh"} {
+		if strings.Contains(resp.Header.Get(header), "details.php") {
+			return true
+		}
+	}
+	lower := bytes.ToLower(body)
+
+	return bytes.Contains(lower, []byte("gelöscht")) || bytes.Contains(lower, []byte("gel&ouml;scht"))
+}
@@ -0,0 +1,109 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Serve the two steps of deleting comment 17
+func commentDeleteHandler(t *testing.T, deletes *int32) http.Handler {
+	page := readFixture(t, "comment_delete.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/comment.php" || query.Get("action") != "delete" || query.Get("cid") != "17" {
+			http.NotFound(w, r)
+			return
+		}
+		if query.Get("sure") != "1" {
+			w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+			w.Write(page)
+			return
+		}
+		atomic.AddInt32(deletes, 1)
+		http.Redirect(w, r, "/details.php?id=205&viewcomm=1#startcomments", http.StatusFound)
+	})
+}
+
+func TestCommentDeleteTwoStep(t *testing.T) {
+	var deletes int32
+	c := newTestConnection(t, commentDeleteHandler(t, &deletes))
+
+	conf, err := CommentDeleteConfirmation(c, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf == nil || conf.Method != "GET" || !strings.Contains(conf.Url, "sure=1") {
+		t.Fatalf("unexpected confirmation %+v", conf)
+	}
+	if !strings.Contains(conf.Message, "Bist du sicher") {
+		t.Errorf("Message = %q", conf.Message)
+	}
+	if deletes != 0 {
+		t.Fatal("deleted before the confirmation")
+	}
+
+	ok, err := Confirm(c, conf)
+	if err != nil || !ok {
+		t.Fatalf("Confirm = %v, %v", ok, err)
+	}
+	if deletes != 1 {
+		t.Errorf("deleted %d times, want once", deletes)
+	}
+}
+
+func TestCommentDelete(t *testing.T) {
+	var deletes int32
+	c := newTestConnection(t, commentDeleteHandler(t, &deletes))
+
+	ok, err := CommentDelete(c, 17)
+	if err != nil || !ok {
+		t.Fatalf("CommentDelete = %v, %v", ok, err)
+	}
+	if deletes != 1 {
+		t.Errorf("deleted %d times, want once", deletes)
+	}
+}
+
+func TestCommentDeleteUnexpectedPage(t *testing.T) {
+	// e.g. a changed layout, neither a confirmation nor a redirect to the torrent
+	c := newTestConnection(t, pageHandler([]byte(`<html><body><a href="browse.php">Torrents</a></body></html>`)))
+
+	if ok, err := CommentDelete(c, 17); ok || err == nil {
+		t.Errorf("CommentDelete = %v, %v, want an error", ok, err)
+	}
+}
+
+func TestCommentDeleteDryRun(t *testing.T) {
+	var requests int32
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Redirect(w, r, "/details.php?id=205", http.StatusFound)
+	}))
+	c.SetDryRun(true)
+
+	if ok, err := CommentDelete(c, 17); err != nil || !ok {
+		t.Errorf("CommentDelete = %v, %v", ok, err)
+	}
+	if requests != 0 {
+		t.Errorf("%d requests sent in dry-run mode", requests)
+	}
+}
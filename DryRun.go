@@ -0,0 +1,105 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+
+package irrenhaus_api
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// Enable the dry run mode. Mutating requests are logged instead of sent to the site
+// and answered with an empty successful response.
+//
+// Stubbed are all POST requests except the login (Upload, CommentWrite, ShoutboxWrite
+// and POSTs through Do), Thank, CommentDelete(Confirmation) and confirmations submitted through Confirm.
+// All other GET requests are still executed.
+func (c *Connection) SetDryRun(dryRun bool) {
+	c.dryRun = dryRun
+}
+
+func (c Connection) isDryRun(req *http.Request) bool {
+	return c.dryRun && path.Base(req.URL.Path) != "takelogin.php"
+}
+
+// Log the request and return a synthetic empty response
+func dryRunResponse(req *http.Request) (*http.Response, error) {
+	log.Printf("[DryRun] %s %s\n", req.Method, req.URL.String())
+
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		logDryRunBody(req.Header.Get("Content-Type"), body)
+	}
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"text/html"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}, nil
+}
+
+func logDryRunBody(contentType string, body []byte) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		log.Printf("[DryRun]     body: %d bytes\n", len(body))
+		return
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return
+		}
+		for key, value := range values {
+			log.Printf("[DryRun]     %s: %s\n", key, value)
+		}
+	case "multipart/form-data":
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FileName() != "" {
+				size, _ := io.Copy(ioutil.Discard, part)
+				log.Printf("[DryRun]     %s: file %q, %d bytes\n", part.FormName(), part.FileName(), size)
+			} else {
+				value, _ := ioutil.ReadAll(part)
+				log.Printf("[DryRun]     %s: %s\n", part.FormName(), value)
+			}
+		}
+	default:
+		log.Printf("[DryRun]     body: %d bytes\n", len(body))
+	}
+}
@@ -0,0 +1,264 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Changes to a torrent, nil fields keep their current value.
+//
+// The attributes are only on the edit form of some categories:
+//   - ThreeD (field "3d"): DVDR, 1080p, 720p, h264/x264, Xvid and 3-D
+//   - Resolution (field "resolution"): 1080p, 720p, Doku HD, Serie HD and their packs
+//   - Source (field "source"): the movie, documentary and series categories
+type TorrentEdits struct {
+	Name        *string
+	Description *string
+	Category    *int
+	ThreeD      *bool
+	// e.g. "1080p", the value or the label of the option
+	Resolution *string
+	// e.g. "BluRay", the value or the label of the option
+	Source *string
+}
+
+// Edit a torrent, only the given fields are changed.
+// The current values are read from the edit form first, so the other fields are submitted unchanged.
+// Returns ErrPermissionDenied if the current user may not edit the torrent and ErrInvalidRequest
+// if an attribute is not on the form of the torrent's category.
+func EditTorrent(c *Connection, torrentId int64, edits TorrentEdits) (bool, error) {
+	if err := c.assureLogin(); err != nil {
+		return false, err
+	}
+
+	form, editUrl, err := fetchEditForm(c, torrentId, "descr")
+	if err != nil {
+		return false, err
+	}
+
+	data := parseFormValues(form)
+	if edits.Name != nil {
+		data.Set("name", *edits.Name)
+	}
+	if edits.Description != nil {
+		data.Set("descr", *edits.Description)
+	}
+	if edits.Category != nil {
+		data.Set("type", strconv.Itoa(*edits.Category))
+	}
+	if edits.ThreeD != nil {
+		field := form.Find("[name='3d']")
+		value, ok := yesNoValue(field, *edits.ThreeD)
+		if !ok {
+			return false, fmt.Errorf("%w: no 3d attribute", ErrInvalidRequest)
+		}
+		if value == "" {
+			data.Del("3d")
+		} else {
+			data.Set("3d", value)
+		}
+	}
+	if edits.Resolution != nil {
+		if err := setOption(form, data, "resolution", *edits.Resolution); err != nil {
+			return false, err
+		}
+	}
+	if edits.Source != nil {
+		if err := setOption(form, data, "source", *edits.Source); err != nil {
+			return false, err
+		}
+	}
+
+	return submitEditForm(c, editUrl, form, data)
+}
+
+// Set a select or radio field to the option with the given value (or label of a select option)
+func setOption(form *goquery.Selection, data url.Values, name, wanted string) error {
+	field := form.Find("[name='" + name + "']")
+	if len(field.Nodes) == 0 {
+		return fmt.Errorf("%w: no %s attribute", ErrInvalidRequest, name)
+	}
+
+	options := field
+	if field.Nodes[0].Data == "select" {
+		options = field.Find("option")
+	}
+	for i := range options.Nodes {
+		option := options.Eq(i)
+		value := option.AttrOr("value", option.Text())
+		if strings.EqualFold(value, wanted) || strings.EqualFold(strings.TrimSpace(option.Text()), wanted) {
+			data.Set(name, value)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: unknown %s %q", ErrInvalidRequest, name, wanted)
+}
+
+// Fetch the edit form of a torrent, the form must contain the given field.
+// Returns ErrPermissionDenied if there is no such form.
+func fetchEditForm(c *Connection, torrentId int64, field string) (*goquery.Selection, string, error) {
+	editUrl := c.buildUrl("edit.php", url.Values{"id": {fmt.Sprintf("%d", torrentId)}})
+	resp, err := c.get(editUrl)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return nil, "", newRequestError(resp, ErrTorrentNotFound)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	form := doc.Find("form").FilterFunction(func(i int, s *goquery.Selection) bool {
+		return len(s.Find("[name="+field+"]").Nodes) > 0
+	}).First()
+	if len(form.Nodes) == 0 {
+		return nil, "", newRequestError(resp, ErrPermissionDenied)
+	}
+
+	return form, editUrl, nil
+}
+
+// Submit the edit form with the given values like the browser does
+func submitEditForm(c *Connection, editUrl string, form *goquery.Selection, data url.Values) (bool, error) {
+	action, _ := form.Attr("action")
+	ref, err := url.Parse(action)
+	if err != nil {
+		return false, err
+	}
+	base, _ := url.Parse(editUrl)
+
+	contentType, postBody, err := encodeLatin1Form(data, strings.HasPrefix(form.AttrOr("enctype", ""), "multipart/"))
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.post(base.ResolveReference(ref).String(), contentType, postBody)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if c.dryRun {
+		return true, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return false, newRequestError(resp, ErrTorrentNotFound)
+	}
+	if ok, err := parseAjaxResult(body); !ok {
+		return false, newRequestError(resp, err)
+	}
+	if resp.StatusCode >= 400 {
+		return false, newRequestError(resp, errors.New("edit failed"))
+	}
+
+	return true, nil
+}
+
+// Get the value to submit for a yes/no field, an empty value leaves the field out.
+// A checkbox is submitted with its own value, radio buttons and selects by their yes/no option.
+func yesNoValue(field *goquery.Selection, yes bool) (string, bool) {
+	if len(field.Nodes) == 0 {
+		return "", false
+	}
+	if field.Nodes[0].Data == "input" && strings.ToLower(field.AttrOr("type", "")) == "checkbox" {
+		if !yes {
+			return "", true
+		}
+		return field.AttrOr("value", "on"), true
+	}
+
+	wanted := []string{"no", "0", "nein"}
+	if yes {
+		wanted = []string{"yes", "1", "ja"}
+	}
+	options := field
+	if field.Nodes[0].Data == "select" {
+		options = field.Find("option")
+	}
+	for i := range options.Nodes {
+		value := options.Eq(i).AttrOr("value", options.Eq(i).Text())
+		for _, w := range wanted {
+			if strings.EqualFold(strings.TrimSpace(value), w) {
+				return value, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Encode the form values as ISO-8859-1, as multipart or urlencoded form.
+// Returns the content type and the body.
+func encodeLatin1Form(data url.Values, multipartForm bool) (string, io.Reader, error) {
+	encoder := encoding.ReplaceUnsupported(charmap.ISO8859_1.NewEncoder())
+	encoded := url.Values{}
+	for name, values := range data {
+		for _, value := range values {
+			v, err := encoder.String(value)
+			if err != nil {
+				return "", nil, err
+			}
+			encoded.Add(name, v)
+		}
+	}
+	if !multipartForm {
+		return "application/x-www-form-urlencoded", strings.NewReader(encoded.Encode()), nil
+	}
+
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+	for name, values := range encoded {
+		for _, value := range values {
+			if err := writer.WriteField(name, value); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", nil, err
+	}
+
+	return writer.FormDataContentType(), buf, nil
+}
@@ -0,0 +1,104 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Serve the edit form and record the submitted form
+func editHandler(t *testing.T, page []byte, submitted *url.Values) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/edit.php":
+			w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+			w.Write(page)
+		case "/takeedit.php":
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Errorf("edit is not posted as multipart form: %v", err)
+			}
+			*submitted = url.Values(r.MultipartForm.Value)
+			http.Redirect(w, r, "/details.php?id=205&edited=1", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func TestEditTorrent(t *testing.T) {
+	var submitted url.Values
+	c := newTestConnection(t, editHandler(t, readFixture(t, "edit_attributes.html"), &submitted))
+
+	threeD, resolution, source := true, "2160p", "webdl"
+	ok, err := EditTorrent(c, 205, TorrentEdits{ThreeD: &threeD, Resolution: &resolution, Source: &source})
+	if err != nil || !ok {
+		t.Fatalf("EditTorrent = %v, %v", ok, err)
+	}
+
+	descr, _ := charmap.ISO8859_1.NewEncoder().String("Grüße aus München & schöne Grüße")
+	expected := map[string]string{
+		"id": "205", "name": "Some.Movie.2018.1080p.BluRay.x264", "descr": descr, "nfoaction": "keep",
+		"type": "17", "visible": "1", "3d": "yes", "resolution": "3", "source": "webdl",
+	}
+	for name, value := range expected {
+		if submitted.Get(name) != value {
+			t.Errorf("%s = %q, want %q", name, submitted.Get(name), value)
+		}
+	}
+
+	threeD, name := false, "Some.Movie.2018.1080p.BluRay.x264-GRP"
+	if _, err := EditTorrent(c, 205, TorrentEdits{Name: &name, ThreeD: &threeD}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := submitted["3d"]; ok {
+		t.Errorf("3d submitted when clearing it: %q", submitted["3d"])
+	}
+	if submitted.Get("name") != name || submitted.Get("resolution") != "1" || submitted.Get("source") != "bluray" {
+		t.Errorf("submitted = %v, want the new name and the current attributes", submitted)
+	}
+}
+
+func TestEditTorrentErrors(t *testing.T) {
+	var submitted url.Values
+	c := newTestConnection(t, editHandler(t, readFixture(t, "edit.html"), &submitted))
+
+	// the series category has no attributes
+	threeD := true
+	if _, err := EditTorrent(c, 205, TorrentEdits{ThreeD: &threeD}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("err = %v, want ErrInvalidRequest", err)
+	}
+
+	c = newTestConnection(t, editHandler(t, readFixture(t, "edit_attributes.html"), &submitted))
+	resolution := "4320p"
+	if _, err := EditTorrent(c, 205, TorrentEdits{Resolution: &resolution}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("err = %v, want ErrInvalidRequest", err)
+	}
+
+	c = newTestConnection(t, editHandler(t, []byte("<html><body><p>Keine Berechtigung</p></body></html>"), &submitted))
+	if _, err := EditTorrent(c, 205, TorrentEdits{ThreeD: &threeD}); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("err = %v, want ErrPermissionDenied", err)
+	}
+	if submitted != nil {
+		t.Error("the form was submitted")
+	}
+}
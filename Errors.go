@@ -0,0 +1,133 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+
+package irrenhaus_api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrAccountParked    = errors.New("account parked")
+	ErrAccountDisabled  = errors.New("account disabled")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrTorrentNotFound  = errors.New("torrent not found")
+	ErrAlreadyReported  = errors.New("already reported")
+	ErrInfoHashMismatch = errors.New("info hash mismatch")
+	ErrSiteMaintenance  = errors.New("site maintenance")
+	ErrRatioTooLow      = errors.New("ratio too low")
+	ErrNotFound         = errors.New("not found")
+	ErrInvalidRequest   = errors.New("invalid request")
+	ErrPinRequired      = errors.New("pin required")
+	// the site answered with its generic error message
+	ErrSiteError = errors.New("site error")
+)
+
+// Reasons of a rejected upload
+var (
+	ErrDuplicate     = errors.New("duplicate")
+	ErrMissingNfo    = errors.New("missing nfo")
+	ErrBannedRelease = errors.New("banned release")
+	ErrWrongCategory = errors.New("wrong category")
+	ErrUploadUnknown = errors.New("unknown error")
+)
+
+// UploadError is returned when the site rejects an upload.
+// Reason is one of the ErrDuplicate, ErrMissingNfo, ErrBannedRelease, ErrWrongCategory
+// or ErrUploadUnknown errors, Message holds the error text of the site.
+type UploadError struct {
+	Reason  error
+	Message string
+}
+
+func (e *UploadError) Error() string {
+	if e.Message == "" {
+		return "upload failed: " + e.Reason.Error()
+	}
+	return "upload failed: " + e.Message
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Reason
+}
+
+// RequestError carries the request which failed along with the underlying error.
+// StatusCode is 0 if no response was received.
+type RequestError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Err        error
+}
+
+func (e *RequestError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Err)
+	}
+	return fmt.Sprintf("%s %s (%d): %s", e.Method, e.URL, e.StatusCode, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func newRequestError(resp *http.Response, err error) error {
+	return &RequestError{
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Err:        err,
+	}
+}
+
+// Markers of the error responses of the mutating (AJAX) endpoints, in order of precedence
+var ajaxErrors = []struct {
+	marker string
+	err    error
+}{
+	{"keine Berechtigung", ErrPermissionDenied},
+	{"Zugriff verweigert", ErrPermissionDenied},
+	// the generic error, see parkedError
+	{"<span>Fehler</span>", ErrSiteError},
+	// sent if a parameter (like the torrent id) is missing
+	{"<span>ERROR</span>", ErrInvalidRequest},
+}
+
+// Classify the response of a mutating request (Thank, CommentWrite, ShoutboxWrite, ...).
+// Returns false and the typed error if the body is one of the known error responses.
+func parseAjaxResult(body []byte) (bool, error) {
+	for _, e := range ajaxErrors {
+		if bytes.Contains(body, []byte(e.marker)) {
+			return false, e.err
+		}
+	}
+
+	return true, nil
+}
+
+// Thank and the shoutbox answer parked accounts with the generic error,
+// for these the generic error is reported as ErrAccountParked
+func parkedError(err error) error {
+	if err == ErrSiteError {
+		return ErrAccountParked
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequestError(t *testing.T) {
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("<html><body>Datenbankfehler</body></html>"))
+	}))
+
+	_, err := Details(c, 205, false, false, false)
+	var requestError *RequestError
+	if !errors.As(err, &requestError) {
+		t.Fatalf("err = %v, want a RequestError", err)
+	}
+	if requestError.Method != "GET" || requestError.StatusCode != 500 || !strings.Contains(requestError.URL, "/details.php?") || !strings.Contains(requestError.URL, "id=205") {
+		t.Errorf("RequestError = %+v, want GET details.php?id=205 with status 500", requestError)
+	}
+	if !strings.Contains(err.Error(), "(500)") {
+		t.Errorf("message %q has no status", err.Error())
+	}
+}
+
+func TestParseAjaxResult(t *testing.T) {
+	responses := []struct {
+		body string
+		ok   bool
+		err  error
+	}{
+		{"", true, nil},
+		{`<div align="center"><b>Danke!</b></div>`, true, nil},
+		{`<table><tr><td><span>Fehler</span></td></tr><tr><td>Dein Account ist geparkt.</td></tr></table>`, false, ErrSiteError},
+		{`<span>ERROR</span> no torrent id`, false, ErrInvalidRequest},
+		{`<span>Fehler</span> Du hast keine Berechtigung diese Aktion auszuf&uuml;hren.`, false, ErrPermissionDenied},
+		{`<h1>Zugriff verweigert</h1>`, false, ErrPermissionDenied},
+	}
+	for _, r := range responses {
+		ok, err := parseAjaxResult([]byte(r.body))
+		if ok != r.ok || err != r.err {
+			t.Errorf("parseAjaxResult(%q) = %v, %v, want %v, %v", r.body, ok, err, r.ok, r.err)
+		}
+	}
+}
+
+func TestAjaxGenericError(t *testing.T) {
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<span>Fehler</span> Das hat nicht geklappt.`))
+	}))
+
+	// only Thank and the shoutbox know the generic error to mean a parked account
+	if _, err := Thank(c, 205); !errors.Is(err, ErrAccountParked) {
+		t.Errorf("Thank: err = %v, want ErrAccountParked", err)
+	}
+	if _, err := CommentWrite(c, 205, "Danke"); !errors.Is(err, ErrSiteError) {
+		t.Errorf("CommentWrite: err = %v, want ErrSiteError", err)
+	}
+	if _, err := CommentDeleteConfirmation(c, 17); !errors.Is(err, ErrSiteError) {
+		t.Errorf("CommentDeleteConfirmation: err = %v, want ErrSiteError", err)
+	}
+}
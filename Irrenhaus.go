@@ -19,31 +19,67 @@
 package irrenhaus_api
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
 )
 
 var DEBUG = false
 
+// Text of the page shown during maintenance (with status 200)
+const defaultMaintenanceMarker = "Wartungsarbeiten"
+
 type Connection struct {
 	url     string
-	cookies Cookies
+	session *sessionState
 
 	username string
 	password string
 	pin      string
 
-	client *http.Client
+	client   *http.Client
+	cache    *responseCache
+	location *time.Location
+
+	userAgent         string
+	dryRun            bool
+	maxConcurrency    int
+	skipLoginCheck    bool
+	maintenanceMarker string
+
+	shoutboxDedupWindow time.Duration
+	commentDedupWindow  time.Duration
+	rssLink             string
+	// the current time for relative dates, time.Now if nil
+	clock func() time.Time
+
+	retries      int
+	retryBackoff time.Duration
+	retryMaxWait time.Duration
+}
 
-	userAgent string
+// The session cookies, shared by the copies of a connection and safe for concurrent use
+type sessionState struct {
+	sync.RWMutex
+	cookies Cookies
+	// held while logging in again after the session expired
+	relogin sync.Mutex
 }
 
 type Cookies struct {
@@ -52,36 +88,123 @@ type Cookies struct {
 	Passhash string
 }
 
+// Check if all session cookies (uid, pass and passhash) are set
+func (c Cookies) IsComplete() bool {
+	return c.Uid != 0 && c.Pass != "" && c.Passhash != ""
+}
+
 func NewConnection(url string, username string, password string, pin string) Connection {
 	c := Connection{url: url, userAgent: "irrenhaus-api client", username: username, password: password, pin: pin}
 	c.client = &http.Client{Timeout: time.Second * 10}
-	c.cookies = Cookies{Uid: 0, Pass: "", Passhash: ""}
+	c.session = &sessionState{}
 	//c.client.CheckRedirect = redirectHandler
-	c.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		return http.ErrUseLastResponse
-	}
+	c.client.CheckRedirect = noRedirect
 
 	return c
 }
 
+// Redirects are not followed, the login check relies on seeing the redirect to login.php
+func noRedirect(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// Use a custom http client, e.g. for a proxy, TLS settings or tracing.
+// The client is copied; if it has no CheckRedirect, redirects are not followed like with the default client.
+// A custom CheckRedirect has to return http.ErrUseLastResponse for redirects to login.php,
+// otherwise expired sessions are not detected.
+func (c *Connection) SetHTTPClient(client *http.Client) {
+	custom := *client
+	if custom.CheckRedirect == nil {
+		custom.CheckRedirect = noRedirect
+	}
+	c.client = &custom
+}
+
 func (c *Connection) SetUserAgent(userAgent string) {
 	c.userAgent = userAgent
 }
 
+// Trust the current cookies (e.g. restored with SetCookies) and skip the login check
+// which is otherwise done by every function with an extra request to my.php.
+// If a GET request is redirected to the login page anyway, the connection logs in and
+// repeats the request once. Other requests (e.g. POSTs) fail in this case,
+// so only use it if the session is known to be valid.
+func (c *Connection) SetSkipLoginCheck(skip bool) {
+	c.skipLoginCheck = skip
+}
+
+// Override the text which identifies the maintenance page, defaults to "Wartungsarbeiten".
+// The text has to be in the title of the page, or anywhere on a page without the navigation
+// of the site, so a torrent or comment mentioning it does not count.
+// While the page is shown, every call fails with ErrSiteMaintenance.
+func (c *Connection) SetMaintenanceMarker(marker string) {
+	c.maintenanceMarker = marker
+}
+
+// Set the maximum number of parallel requests of the batch functions (like DetailsMany), defaults to 4
+func (c *Connection) SetMaxConcurrency(n int) {
+	c.maxConcurrency = n
+}
+
+func (c Connection) getMaxConcurrency() int {
+	if c.maxConcurrency <= 0 {
+		return 4
+	}
+	return c.maxConcurrency
+}
+
+// Set the time zone of the dates shown on the site, defaults to the local time zone
+func (c *Connection) SetLocation(location *time.Location) {
+	c.location = location
+}
+
+func (c Connection) getLocation() *time.Location {
+	if c.location == nil {
+		return time.Local
+	}
+	return c.location
+}
+
+// The current time in the location of the site, relative dates are based on it
+func (c Connection) now() time.Time {
+	if c.clock == nil {
+		return time.Now().In(c.getLocation())
+	}
+	return c.clock().In(c.getLocation())
+}
+
 func (c Connection) GetCookies() Cookies {
-	return c.cookies
+	if c.session == nil {
+		return Cookies{}
+	}
+	c.session.RLock()
+	defer c.session.RUnlock()
+	return c.session.cookies
 }
 
 func (c *Connection) SetCookies(cookies Cookies) {
-	c.cookies = cookies
+	if c.session == nil {
+		c.session = &sessionState{}
+	}
+	c.session.Lock()
+	c.session.cookies = cookies
+	c.session.Unlock()
 }
 
+// Build the url of the page, the path may already contain a query
 func (c Connection) buildUrl(url string, values url.Values) string {
-	if url[0] != '/' {
+	if !strings.HasPrefix(url, "/") {
 		url = "/" + url
 	}
 	if len(values) > 0 {
-		return c.url + url + "?" + values.Encode()
+		separator := "?"
+		if strings.Contains(url, "?") {
+			separator = "&"
+			if strings.HasSuffix(url, "?") || strings.HasSuffix(url, "&") {
+				separator = ""
+			}
+		}
+		return c.url + url + separator + values.Encode()
 	}
 	return c.url + url
 }
@@ -100,41 +223,285 @@ func (c *Connection) Login() error {
 		return errors.New("invalid credentials")
 	}
 
-	for _, cookie := range resp.Cookies() {
+	responseCookies := resp.Cookies()
+	// some accounts have to enter the pin on a second page
+	if form := loginPinForm(body); form != nil {
+		if c.pin == "" {
+			return ErrPinRequired
+		}
+		debugLog("[Login] Sending pin")
+		pinResp, pinBody, err := c.submitLoginPin(form, resp.Request.URL, responseCookies)
+		if err != nil {
+			return err
+		}
+		defer pinResp.Body.Close()
+		if strings.Contains(string(pinBody), "Anmeldung Gescheitert!") || loginPinForm(pinBody) != nil {
+			return errors.New("invalid pin")
+		}
+		resp, body = pinResp, pinBody
+		responseCookies = append(responseCookies, pinResp.Cookies()...)
+	}
+
+	cookies := Cookies{}
+	for _, cookie := range responseCookies {
 		switch cookie.Name {
 		case "uid":
-			c.cookies.Uid, _ = strconv.ParseInt(cookie.Value, 10, 64)
+			cookies.Uid, _ = strconv.ParseInt(cookie.Value, 10, 64)
 		case "pass":
-			c.cookies.Pass = cookie.Value
+			cookies.Pass = cookie.Value
 		case "passhash":
-			c.cookies.Passhash = cookie.Value
+			cookies.Passhash = cookie.Value
 		}
 	}
 
+	if cookies.Uid == 0 || cookies.Pass == "" {
+		return errors.New("login response is missing the session cookies")
+	}
+	if !cookies.IsComplete() {
+		// some skins need the passhash, without it every request may be redirected to the login
+		debugLog("[Login] Warning: login response is missing the passhash cookie")
+	}
+
+	// a parked or disabled account is logged in, but lands on a restricted page
+	landing := body
+	if location, err := resp.Location(); err == nil {
+		session := *c
+		session.session = &sessionState{cookies: cookies}
+		req, err := session.newRequest("GET", location.String(), nil)
+		if err != nil {
+			return err
+		}
+		landingResp, err := c.do(req)
+		if err != nil {
+			return err
+		}
+		defer landingResp.Body.Close()
+		landing, err = ioutil.ReadAll(landingResp.Body)
+		if err != nil {
+			return err
+		}
+		debugRequest(landingResp, string(landing))
+	}
+	if err := accountStateError(landing); err != nil {
+		debugLog("[Login] Account not usable:", err.Error())
+		return err
+	}
+
+	c.SetCookies(cookies)
 	debugLog("[Login] Logged in")
 
 	return nil
 }
 
 func (c Connection) postForm(url string, data url.Values) (resp *http.Response, err error) {
-	return c.post(url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+	return c.postFormContext(context.Background(), url, data)
+}
+
+func (c Connection) postFormContext(ctx context.Context, url string, data url.Values) (resp *http.Response, err error) {
+	return c.postContext(ctx, url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
 }
 
 func (c Connection) post(url string, contentType string, body io.Reader) (resp *http.Response, err error) {
+	return c.postContext(context.Background(), url, contentType, body)
+}
+
+func (c Connection) postContext(ctx context.Context, url string, contentType string, body io.Reader) (resp *http.Response, err error) {
 	req, err := c.newRequest("POST", url, body)
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", contentType)
-	return c.client.Do(req)
+	if c.isDryRun(req) {
+		return dryRunResponse(req)
+	}
+	return c.do(req)
 }
 
-func (c Connection) get(url string) (resp *http.Response, err error) {
+func (c *Connection) get(url string) (resp *http.Response, err error) {
+	return c.getContext(context.Background(), url)
+}
+
+func (c *Connection) getContext(ctx context.Context, url string) (resp *http.Response, err error) {
+	sent := c.GetCookies()
 	req, err := c.newRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	return c.client.Do(req)
+	req = req.WithContext(ctx)
+
+	cacheable := c.cache != nil && isCacheable(req)
+	if cacheable {
+		if resp := c.cache.lookup(req); resp != nil {
+			debugLog("[Cache] hit", url)
+			return resp, nil
+		}
+		c.cache.addValidators(req)
+	}
+
+	resp, err = c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if c.skipLoginCheck && isLoginRedirect(resp) {
+		debugLog("[Login] Session expired")
+		resp.Body.Close()
+		if err := c.relogin(sent); err != nil {
+			return nil, err
+		}
+		req, err = c.newRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		if cacheable {
+			c.cache.addValidators(req)
+		}
+		resp, err = c.do(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cacheable && resp.StatusCode == 304 {
+		if cached := c.cache.revalidate(req); cached != nil {
+			debugLog("[Cache] not modified", url)
+			resp.Body.Close()
+			return cached, nil
+		}
+	}
+	if cacheable && resp.StatusCode == 200 {
+		if err := c.cache.store(resp); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// Log in again after the session expired, sent are the cookies of the rejected request.
+// Concurrent requests share the login: if the cookies changed in the meantime,
+// another request already logged in.
+func (c *Connection) relogin(sent Cookies) error {
+	if c.session == nil {
+		return c.Login()
+	}
+	c.session.relogin.Lock()
+	defer c.session.relogin.Unlock()
+
+	if c.GetCookies() != sent {
+		return nil
+	}
+
+	return c.Login()
+}
+
+// Do performs a request against an endpoint the wrapper does not cover (yet).
+//
+// This is meant for advanced use: the request carries the session cookies and
+// the login is assured before it is sent, but the response is returned as-is for
+// custom parsing. Text responses without a charset or in ISO-8859-1 are decoded to UTF-8,
+// other charsets and binary responses (like .torrent files) are left untouched.
+// The caller has to close the response body.
+func (c *Connection) Do(method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(method, c.buildUrl(path, query), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if method == "POST" && c.isDryRun(req) {
+		return dryRunResponse(req)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if isLatin1Text(resp.Header.Get("Content-Type")) {
+		resp.Body = decodedBody{
+			Reader: transform.NewReader(resp.Body, charmap.ISO8859_1.NewDecoder()),
+			Closer: resp.Body,
+		}
+	}
+
+	return resp, nil
+}
+
+// Check for a text content type in ISO-8859-1, the charset of the site if none is given
+func isLatin1Text(contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "text/") {
+		return false
+	}
+	switch strings.ToLower(params["charset"]) {
+	case "", "iso-8859-1", "iso8859-1", "latin1", "latin-1":
+		return true
+	}
+
+	return false
+}
+
+// decodedBody reads the decoded response, but closes the original body
+type decodedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// Send the request, transport errors and server errors (5xx) are returned as RequestError
+func (c Connection) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, &RequestError{Method: req.Method, URL: req.URL.String(), Err: err}
+	}
+	if resp.StatusCode >= 500 {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		debugRequest(resp, string(body))
+		return nil, newRequestError(resp, errors.New(resp.Status))
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, newRequestError(resp, err)
+		}
+		marker := c.maintenanceMarker
+		if marker == "" {
+			marker = defaultMaintenanceMarker
+		}
+		if isMaintenancePage(body, marker) {
+			debugRequest(resp, string(body))
+			return nil, newRequestError(resp, ErrSiteMaintenance)
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	return resp, nil
+}
+
+// The maintenance page has the marker in its title or is a bare page with the marker,
+// the regular pages (including the login page) link to the other pages of the site
+func isMaintenancePage(body []byte, marker string) bool {
+	if !bytes.Contains(body, []byte(marker)) {
+		return false
+	}
+	tre, _ := regexp.Compile("(?is)<title[^>]*>(.*?)</title>")
+	if m := tre.FindSubmatch(body); m != nil && bytes.Contains(m[1], []byte(marker)) {
+		return true
+	}
+	for _, page := range []string{"browse.php", "logout.php", "takelogin.php", "login.php"} {
+		if bytes.Contains(body, []byte(page)) {
+			return false
+		}
+	}
+
+	return true
 }
 
 func (c Connection) newRequest(method, url string, body io.Reader) (*http.Request, error) {
@@ -142,19 +509,153 @@ func (c Connection) newRequest(method, url string, body io.Reader) (*http.Reques
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("UserAgent", c.userAgent)
-	if c.cookies.Uid != 0 {
-		req.AddCookie(&http.Cookie{Name: "uid", Value: fmt.Sprintf("%d", c.cookies.Uid)})
-		req.AddCookie(&http.Cookie{Name: "pass", Value: c.cookies.Pass})
-		if c.cookies.Passhash != "" {
-			req.AddCookie(&http.Cookie{Name: "passhash", Value: c.cookies.Passhash})
+	req.Header.Set("User-Agent", c.userAgent)
+	if cookies := c.GetCookies(); cookies.Uid != 0 {
+		req.AddCookie(&http.Cookie{Name: "uid", Value: fmt.Sprintf("%d", cookies.Uid)})
+		req.AddCookie(&http.Cookie{Name: "pass", Value: cookies.Pass})
+		if cookies.Passhash != "" {
+			req.AddCookie(&http.Cookie{Name: "passhash", Value: cookies.Passhash})
 		}
 	}
 
 	return req, nil
 }
 
+func isLoginRedirect(resp *http.Response) bool {
+	location, err := resp.Location()
+	return err == nil && strings.HasPrefix(location.Path, "/login.php")
+}
+
+// Find the form of the separate pin page, nil if the page is something else
+func loginPinForm(body []byte) *goquery.Selection {
+	if !bytes.Contains(body, []byte("name=\"pin\"")) {
+		return nil
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	form := doc.Find("form").FilterFunction(func(i int, s *goquery.Selection) bool {
+		return len(s.Find("input[name=pin]").Nodes) > 0 && len(s.Find("input[name=password]").Nodes) == 0
+	}).First()
+	if len(form.Nodes) == 0 {
+		return nil
+	}
+
+	return form
+}
+
+// Post the pin with the form of the pin page, the cookies of the login response are sent along
+func (c *Connection) submitLoginPin(form *goquery.Selection, pageUrl *url.URL, cookies []*http.Cookie) (*http.Response, []byte, error) {
+	action, _ := form.Attr("action")
+	ref, err := url.Parse(action)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := parseHiddenFields(form)
+	data.Set("pin", c.pin)
+
+	req, err := c.newRequest("POST", pageUrl.ResolveReference(ref).String(), strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, nil, err
+	}
+	debugRequest(resp, string(body))
+
+	return resp, body, nil
+}
+
+// Detect the pages shown to parked or disabled accounts
+func accountStateError(body []byte) error {
+	lower := bytes.ToLower(body)
+	if bytes.Contains(lower, []byte("account ist geparkt")) || bytes.Contains(lower, []byte("account wurde geparkt")) {
+		return ErrAccountParked
+	}
+	if bytes.Contains(lower, []byte("account wurde deaktiviert")) || bytes.Contains(lower, []byte("account ist deaktiviert")) ||
+		bytes.Contains(lower, []byte("account wurde gesperrt")) {
+		return ErrAccountDisabled
+	}
+
+	return nil
+}
+
+// End the session on the site and forget the session cookies and the RSS link.
+// The next request logs in again.
+func (c *Connection) Logout() error {
+	if c.GetCookies().Uid == 0 {
+		return nil
+	}
+
+	req, err := c.newRequest("GET", c.buildUrl("logout.php", nil), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	debugRequest(resp, string(body))
+
+	// a logged in page links to the logout
+	if bytes.Contains(body, []byte("logout.php")) {
+		return newRequestError(resp, errors.New("logout failed"))
+	}
+
+	c.SetCookies(Cookies{})
+	c.ClearCache()
+	// the feed link contains the passkey of the account
+	c.rssLink = ""
+
+	return nil
+}
+
+// Check if the session is valid, like the check before every request but without logging in
+func (c *Connection) IsLoggedIn() (bool, error) {
+	if c.GetCookies().Uid == 0 {
+		return false, nil
+	}
+
+	req, err := c.newRequest("GET", c.buildUrl("/my.php", nil), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	debugRequest(resp, string(body))
+
+	return !isLoginRedirect(resp), nil
+}
+
 func (c *Connection) assureLogin() error {
+	if c.skipLoginCheck && c.GetCookies().Uid != 0 {
+		return nil
+	}
+
 	resp, err := c.get(c.buildUrl("/my.php", nil))
 	if err != nil {
 		return err
@@ -189,6 +690,16 @@ func (c *Connection) assureLogin() error {
 	return nil
 }
 
+// Read the ISO-8859-1 encoded body and convert it to UTF-8.
+func readLatin1(r io.Reader) ([]byte, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return charmap.ISO8859_1.NewDecoder().Bytes(raw)
+}
+
 func keepLines(s string, n int) string {
 	if strings.Count(s, "\n") < 3 {
 		return s
@@ -197,11 +708,21 @@ func keepLines(s string, n int) string {
 	return strings.Replace(result, "\r", "", -1)
 }
 
+// Replace passkeys (e.g. of announce urls) and rss keys in debug output.
+// Covers query parameters ending in "key" (passkey=, key=, rsskey=) and the passkey
+// as a path segment before or after "announce".
+func redactPasskey(s string) string {
+	re, _ := regexp.Compile("(?i)(key=|key/|/announce/)[0-9a-z]{32}")
+	s = re.ReplaceAllString(s, "${1}[redacted]")
+	pre, _ := regexp.Compile("(?i)/[0-9a-f]{32}(/announce)")
+	return pre.ReplaceAllString(s, "/[redacted]${1}")
+}
+
 func debugRequest(resp *http.Response, body string) {
 	if !DEBUG {
 		return
 	}
-	log.Printf("> %s %s://%s%s\n", resp.Request.Method, resp.Request.URL.Scheme, resp.Request.Host, resp.Request.URL.RequestURI())
+	log.Printf("> %s %s://%s%s\n", resp.Request.Method, resp.Request.URL.Scheme, resp.Request.Host, redactPasskey(resp.Request.URL.RequestURI()))
 	log.Println("Request:")
 	if resp.Request.Method == "POST" {
 		for key, value := range resp.Request.Form {
@@ -224,7 +745,7 @@ func debugRequest(resp *http.Response, body string) {
 		log.Println("[body ommited]")
 	} else {
 		log.Println("[body truncated]")
-		log.Println(keepLines(body, 3))
+		log.Println(redactPasskey(keepLines(body, 3)))
 	}
 
 	log.Println("")
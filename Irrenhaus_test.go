@@ -0,0 +1,358 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Create a connection to a test server with a session, so no login is attempted
+func newTestConnection(t *testing.T, handler http.Handler) *Connection {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	c := NewConnection(server.URL, "user", "secret", "")
+	c.SetCookies(Cookies{Uid: 42, Pass: "pass", Passhash: "hash"})
+	c.SetSkipLoginCheck(true)
+
+	return &c
+}
+
+// Read a file of the testdata directory
+func readFixture(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func pageHandler(page []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(page)
+	})
+}
+
+func TestDoCharset(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        string
+	}{
+		{"text/html", "Gr\xf6\xdfe", "Größe"},
+		{"text/html; charset=ISO-8859-1", "Gr\xf6\xdfe", "Größe"},
+		{"text/html; charset=utf-8", "Größe", "Größe"},
+		{"application/x-bittorrent", "d4:name\xf6e", "d4:name\xf6e"},
+	}
+	for _, test := range tests {
+		c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.Write([]byte(test.body))
+		}))
+		resp, err := c.Do("GET", "/page.php", nil, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != test.want {
+			t.Errorf("%s: body = %q, %v, want %q", test.contentType, body, err, test.want)
+		}
+	}
+}
+
+func TestReloginConcurrent(t *testing.T) {
+	var mutex sync.Mutex
+	logins := 0
+	page := readFixture(t, "browse.html")
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/takelogin.php" {
+			mutex.Lock()
+			logins++
+			mutex.Unlock()
+			// give the other requests time to run into the expired session
+			time.Sleep(50 * time.Millisecond)
+			http.SetCookie(w, &http.Cookie{Name: "uid", Value: "42"})
+			http.SetCookie(w, &http.Cookie{Name: "pass", Value: "renewed"})
+			http.SetCookie(w, &http.Cookie{Name: "passhash", Value: "hash"})
+			w.Write([]byte("<html><body>Willkommen</body></html>"))
+			return
+		}
+		if cookie, err := r.Cookie("pass"); err != nil || cookie.Value != "renewed" {
+			http.Redirect(w, r, "/login.php?returnto=browse.php", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(page)
+	}))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entries, err := Search(c, "", nil, false)
+			if err == nil && len(entries) != 3 {
+				err = fmt.Errorf("got %d entries, want 3", len(entries))
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if logins != 1 {
+		t.Errorf("logged in %d times, want once", logins)
+	}
+}
+
+// A reader that fails after returning its data
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+
+	return n, nil
+}
+
+func TestReadLatin1(t *testing.T) {
+	body, err := readLatin1(strings.NewReader("Gr\xf6\xdfe \xff"))
+	if err != nil || string(body) != "Größe ÿ" {
+		t.Errorf("readLatin1 = %q, %v, want %q", body, err, "Größe ÿ")
+	}
+
+	body, err = readLatin1(&failingReader{data: "<html><body>"})
+	if err == nil || body != nil {
+		t.Errorf("readLatin1 of a broken body = %q, %v, want an error", body, err)
+	}
+}
+
+func TestRedactPasskey(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef"
+	tests := map[string]string{
+		"/rss.php?cat=7&key=" + key:                                "/rss.php?cat=7&key=[redacted]",
+		"https://tracker.example/announce.php?passkey=" + key:      "https://tracker.example/announce.php?passkey=[redacted]",
+		"https://tracker.example/announce.php?PASSKEY=" + key:      "https://tracker.example/announce.php?PASSKEY=[redacted]",
+		"https://tracker.example/announce/" + key:                  "https://tracker.example/announce/[redacted]",
+		"https://tracker.example/" + key + "/announce":             "https://tracker.example/[redacted]/announce",
+		"https://tracker.example/passkey/" + key + "/announce.php": "https://tracker.example/passkey/[redacted]/announce.php",
+		"/download.php?torrent=205":                                "/download.php?torrent=205",
+	}
+	for input, want := range tests {
+		if got := redactPasskey(input); got != want {
+			t.Errorf("redactPasskey(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestBuildUrl(t *testing.T) {
+	c := NewConnection("https://irrenhaus.example", "user", "secret", "")
+	tests := []struct {
+		path   string
+		values url.Values
+		want   string
+	}{
+		{"details.php", url.Values{"id": {"205"}}, "https://irrenhaus.example/details.php?id=205"},
+		{"/details.php", nil, "https://irrenhaus.example/details.php"},
+		{"browse.php", url.Values{}, "https://irrenhaus.example/browse.php"},
+		{"browse.php?cat=7", url.Values{"page": {"2"}}, "https://irrenhaus.example/browse.php?cat=7&page=2"},
+		{"browse.php?cat=7", nil, "https://irrenhaus.example/browse.php?cat=7"},
+		{"browse.php?", url.Values{"page": {"2"}}, "https://irrenhaus.example/browse.php?page=2"},
+		{"browse.php?cat=7&", url.Values{"page": {"2"}}, "https://irrenhaus.example/browse.php?cat=7&page=2"},
+	}
+	for _, test := range tests {
+		if got := c.buildUrl(test.path, test.values); got != test.want {
+			t.Errorf("buildUrl(%q, %v) = %q, want %q", test.path, test.values, got, test.want)
+		}
+	}
+}
+
+// Accept every login and redirect to the landing page, which is only served with the new session
+func loginHandler(t *testing.T, landing []byte, passhash bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/takelogin.php":
+			http.SetCookie(w, &http.Cookie{Name: "uid", Value: "42"})
+			http.SetCookie(w, &http.Cookie{Name: "pass", Value: "new"})
+			if passhash {
+				http.SetCookie(w, &http.Cookie{Name: "passhash", Value: "hash"})
+			}
+			http.Redirect(w, r, "/index.php", http.StatusFound)
+		case "/index.php":
+			if cookie, err := r.Cookie("pass"); err != nil || cookie.Value != "new" {
+				t.Errorf("landing page requested without the new session: %v", err)
+			}
+			w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+			w.Write(landing)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func TestLoginAccountState(t *testing.T) {
+	tests := []struct {
+		fixture string
+		err     error
+	}{
+		{"login_parked.html", ErrAccountParked},
+		{"login_disabled.html", ErrAccountDisabled},
+		{"index.html", nil},
+	}
+	for _, test := range tests {
+		c := newTestConnection(t, loginHandler(t, readFixture(t, test.fixture), true))
+		c.SetCookies(Cookies{})
+
+		err := c.Login()
+		if err != test.err {
+			t.Errorf("%s: Login = %v, want %v", test.fixture, err, test.err)
+		}
+		// the session is only kept if the account is usable
+		if cookies := c.GetCookies(); (test.err == nil) != (cookies.Uid != 0) {
+			t.Errorf("%s: cookies = %+v after Login = %v", test.fixture, cookies, err)
+		}
+	}
+}
+
+func TestLoginWithoutPasshash(t *testing.T) {
+	c := newTestConnection(t, loginHandler(t, readFixture(t, "index.html"), false))
+	c.SetCookies(Cookies{})
+
+	// logged in with a warning, the caller can check IsComplete
+	if err := c.Login(); err != nil {
+		t.Fatal(err)
+	}
+	cookies := c.GetCookies()
+	if cookies.Uid != 42 || cookies.Pass != "new" || cookies.Passhash != "" {
+		t.Errorf("cookies = %+v, want uid and pass without passhash", cookies)
+	}
+	if cookies.IsComplete() {
+		t.Error("IsComplete without passhash")
+	}
+	if !(Cookies{Uid: 42, Pass: "new", Passhash: "hash"}).IsComplete() {
+		t.Error("not IsComplete with all three cookies")
+	}
+}
+
+// Log in with the pin either in the login form or on a second page, the pin is 1234
+func pinLoginHandler(t *testing.T, twoStep bool) http.Handler {
+	pinPage := readFixture(t, "login_pin.html")
+	index := readFixture(t, "index.html")
+	loggedIn := func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "uid", Value: "42"})
+		http.SetCookie(w, &http.Cookie{Name: "pass", Value: "new"})
+		http.SetCookie(w, &http.Cookie{Name: "passhash", Value: "hash"})
+		http.Redirect(w, r, "/index.php", http.StatusFound)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		r.ParseForm()
+		switch r.URL.Path {
+		case "/takelogin.php":
+			if !twoStep {
+				if r.PostForm.Get("pin") != "1234" {
+					w.Write([]byte("<html><body>Anmeldung Gescheitert!</body></html>"))
+					return
+				}
+				loggedIn(w, r)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "loginstep", Value: "pin"})
+			w.Write(pinPage)
+		case "/takepin.php":
+			if cookie, err := r.Cookie("loginstep"); err != nil || cookie.Value != "pin" || r.PostForm.Get("token") != "f00ba4" {
+				t.Errorf("pin sent without the cookie or token of the login: %v, %v", err, r.PostForm)
+			}
+			if r.PostForm.Get("pin") != "1234" {
+				w.Write(pinPage)
+				return
+			}
+			loggedIn(w, r)
+		case "/index.php":
+			w.Write(index)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func TestLoginPin(t *testing.T) {
+	tests := []struct {
+		twoStep bool
+		pin     string
+		err     error
+	}{
+		{false, "1234", nil},
+		{true, "1234", nil},
+		{true, "", ErrPinRequired},
+		{true, "0000", errors.New("invalid pin")},
+	}
+	for _, test := range tests {
+		c := newTestConnection(t, pinLoginHandler(t, test.twoStep))
+		c.SetCookies(Cookies{})
+		c.pin = test.pin
+
+		err := c.Login()
+		if fmt.Sprint(err) != fmt.Sprint(test.err) || (test.err == ErrPinRequired && err != ErrPinRequired) {
+			t.Errorf("two step %v, pin %q: Login = %v, want %v", test.twoStep, test.pin, err, test.err)
+		}
+		if (err == nil) != c.GetCookies().IsComplete() {
+			t.Errorf("two step %v, pin %q: cookies = %+v after Login = %v", test.twoStep, test.pin, c.GetCookies(), err)
+		}
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	agents := make(chan string, 1)
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.Header.Get("User-Agent")
+	}))
+	c.SetUserAgent("irrenhaus-test/1.0")
+
+	resp, err := c.Do("GET", "/index.php", nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if agent := <-agents; agent != "irrenhaus-test/1.0" {
+		t.Errorf("User-Agent = %q, want %q", agent, "irrenhaus-test/1.0")
+	}
+}
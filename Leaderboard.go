@@ -0,0 +1,129 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type LeaderboardKind int
+
+const (
+	// Value is the uploaded amount in bytes
+	LeaderboardUploaders LeaderboardKind = iota
+	// Value is the number of completed torrents
+	LeaderboardSnatchers
+	// Value is the number of given thanks
+	LeaderboardThankers
+)
+
+type LeaderboardEntry struct {
+	Rank   int
+	User   string
+	UserId int
+	// meaning depends on the LeaderboardKind
+	Value int64
+}
+
+// Get a leaderboard of the stats page (top 10), in rank order
+func Leaderboard(c *Connection, kind LeaderboardKind) ([]LeaderboardEntry, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	var subtype string
+	switch kind {
+	case LeaderboardUploaders:
+		subtype = "ul"
+	case LeaderboardSnatchers:
+		subtype = "dl"
+	case LeaderboardThankers:
+		subtype = "thx"
+	default:
+		return nil, errors.New("unknown leaderboard")
+	}
+
+	resp, err := c.get(c.buildUrl("topten.php", url.Values{"type": {"1"}, "subtype": {subtype}}))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	debugRequest(resp, string(body))
+
+	if bytes.Contains(body, []byte("keine Berechtigung")) || bytes.Contains(body, []byte("Zugriff verweigert")) {
+		return nil, newRequestError(resp, ErrPermissionDenied)
+	}
+
+	return parseLeaderboard(bytes.NewReader(body), kind)
+}
+
+func parseLeaderboard(reader io.Reader, kind LeaderboardKind) ([]LeaderboardEntry, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]LeaderboardEntry, 0)
+	cre, _ := regexp.Compile("[\\d.]+")
+
+	doc.Find("table.tableinborder tr").Each(func(i int, tr *goquery.Selection) {
+		tds := tr.Find("td")
+		if len(tds.Nodes) < 3 || len(tr.Find("a[href*=userdetails]").Nodes) == 0 {
+			// header or layout row
+			return
+		}
+
+		rank, err := strconv.ParseInt(strings.TrimSpace(tds.Eq(0).Text()), 10, 32)
+		if err != nil {
+			return
+		}
+		entry := LeaderboardEntry{Rank: int(rank)}
+		entry.User, entry.UserId = parseUserLink(tr)
+
+		value := strings.TrimSpace(tds.Eq(2).Text())
+		if kind == LeaderboardUploaders {
+			entry.Value = int64(stringToDatasize(value))
+		} else {
+			count, err := strconv.ParseInt(strings.Replace(cre.FindString(value), ".", "", -1), 10, 64)
+			if err == nil {
+				entry.Value = count
+			}
+		}
+
+		entries = append(entries, entry)
+	})
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Rank < entries[j].Rank
+	})
+
+	return entries, nil
+}
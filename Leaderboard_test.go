@@ -0,0 +1,78 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func leaderboardHandler(t *testing.T) http.Handler {
+	pages := map[string][]byte{
+		"ul":  readFixture(t, "topten_ul.html"),
+		"dl":  readFixture(t, "topten_dl.html"),
+		"thx": readFixture(t, "topten_thx.html"),
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Query().Get("subtype")]
+		if r.URL.Path != "/topten.php" || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(page)
+	})
+}
+
+func TestLeaderboard(t *testing.T) {
+	c := newTestConnection(t, leaderboardHandler(t))
+
+	tests := []struct {
+		kind    LeaderboardKind
+		entries []LeaderboardEntry
+	}{
+		{LeaderboardUploaders, []LeaderboardEntry{{1, "alice", 10, 1649267441664}, {2, "bob", 11, 751619276800}, {3, "user", 42, 536870912}}},
+		// the page is not in rank order
+		{LeaderboardSnatchers, []LeaderboardEntry{{1, "carol", 12, 1234}, {2, "bob", 11, 987}, {3, "alice", 10, 56}}},
+		{LeaderboardThankers, []LeaderboardEntry{{1, "dave", 13, 2001}, {2, "user", 42, 17}}},
+	}
+	for _, test := range tests {
+		entries, err := Leaderboard(c, test.kind)
+		if err != nil {
+			t.Errorf("kind %d: %v", test.kind, err)
+			continue
+		}
+		if fmt.Sprint(entries) != fmt.Sprint(test.entries) {
+			t.Errorf("kind %d: got %v, want %v", test.kind, entries, test.entries)
+		}
+	}
+
+	if _, err := Leaderboard(c, LeaderboardKind(7)); err == nil {
+		t.Error("got a leaderboard of an unknown kind")
+	}
+}
+
+func TestLeaderboardPermissionDenied(t *testing.T) {
+	c := newTestConnection(t, pageHandler([]byte("<html><body>Du hast keine Berechtigung, diese Seite zu sehen.</body></html>")))
+
+	if _, err := Leaderboard(c, LeaderboardUploaders); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("err = %v, want ErrPermissionDenied", err)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Technical data of a video release. Fields the site does not show are empty.
+type MediaInfo struct {
+	// e.g. "1080p"
+	Resolution string
+	// e.g. "BluRay" or "WEB-DL", taken from the release name
+	Source     string
+	VideoCodec string
+	AudioCodec string
+	// as shown by the site, e.g. "2 h 5 min"
+	Runtime string
+}
+
+// Parse the mediainfo block of the details page.
+// Returns nil if the page has none.
+func parseMediaInfo(reader io.Reader, name string) (*MediaInfo, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	text := ""
+	if td := findTdByLabel(doc.Find("tr"), "MediaInfo", "Mediainfo"); td != nil {
+		text = td.Text()
+	} else {
+		doc.Find("pre").EachWithBreak(func(i int, pre *goquery.Selection) bool {
+			if strings.Contains(pre.Text(), "Video") && strings.Contains(pre.Text(), "Format") {
+				text = pre.Text()
+				return false
+			}
+			return true
+		})
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	info := &MediaInfo{}
+	section := ""
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			// section header like "Video" or "Audio #1"
+			if line != "" {
+				section = strings.ToLower(strings.Fields(line)[0])
+			}
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		switch {
+		case (section == "general" || section == "allgemein") && (key == "duration" || key == "dauer"):
+			info.Runtime = value
+		case section == "video" && key == "format" && info.VideoCodec == "":
+			info.VideoCodec = value
+		case section == "video" && (key == "height" || key == "höhe") && info.Resolution == "":
+			height := strings.Map(func(r rune) rune {
+				if r >= '0' && r <= '9' {
+					return r
+				}
+				return -1
+			}, value)
+			if height != "" {
+				info.Resolution = height + "p"
+			}
+		case section == "audio" && key == "format" && info.AudioCodec == "":
+			info.AudioCodec = value
+		}
+	}
+
+	// the release name tells the rest
+	if info.Resolution == "" {
+		re, _ := regexp.Compile("(?i)\\b(2160p|1080p|1080i|720p|576p|480p)\\b")
+		info.Resolution = strings.ToLower(re.FindString(name))
+	}
+	if info.VideoCodec == "" {
+		re, _ := regexp.Compile("(?i)\\b(x264|x265|h\\.?264|h\\.?265|hevc|xvid)\\b")
+		info.VideoCodec = re.FindString(name)
+	}
+	sre, _ := regexp.Compile("(?i)\\b(blu-?ray|bdrip|web-?dl|webrip|hdtv|dvdrip|dvdr|hddvd)\\b")
+	info.Source = sre.FindString(name)
+
+	return info, nil
+}
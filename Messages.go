@@ -0,0 +1,159 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// A private message as listed in the inbox or outbox
+type Message struct {
+	Id      int64
+	Subject string
+	// the sender in the inbox, the receiver in the outbox
+	User   string
+	UserId int
+	Date   time.Time
+	Unread bool
+}
+
+// Get the private messages of the inbox, newest first
+func Inbox(c *Connection) ([]Message, error) {
+	return mailbox(c, "in")
+}
+
+// Get the sent private messages, newest first
+func Outbox(c *Connection) ([]Message, error) {
+	return mailbox(c, "out")
+}
+
+func mailbox(c *Connection, folder string) ([]Message, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.get(c.buildUrl("messages.php", url.Values{"folder": {folder}}))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	debugRequest(resp, string(body))
+
+	return parseMailbox(bytes.NewReader(body), c.getLocation())
+}
+
+func parseMailbox(reader io.Reader, location *time.Location) ([]Message, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0)
+	ire, _ := regexp.Compile("[?&]id=(\\d+)")
+	dre, _ := regexp.Compile("\\d{4}-\\d{2}-\\d{2} \\d{2}:\\d{2}:\\d{2}")
+
+	doc.Find("table.tableinborder tr").Each(func(i int, tr *goquery.Selection) {
+		link := tr.Find("a[href*='action=viewmessage']").First()
+		href, _ := link.Attr("href")
+		m := ire.FindStringSubmatch(href)
+		if m == nil {
+			// header or layout row
+			return
+		}
+
+		message := Message{Subject: strings.TrimSpace(link.Text())}
+		message.Id, _ = strconv.ParseInt(m[1], 10, 64)
+		if len(tr.Find("a[href*=userdetails]").Nodes) > 0 {
+			message.User, message.UserId = parseUserLink(tr)
+		}
+		date, err := time.ParseInLocation("2006-01-02 15:04:05", dre.FindString(tr.Text()), location)
+		if err == nil {
+			message.Date = date
+		}
+		// unread messages are marked with an icon
+		message.Unread = len(tr.Find("img[src*=unread]").Nodes) > 0
+
+		messages = append(messages, message)
+	})
+
+	return messages, nil
+}
+
+// Mark all private messages of the inbox as read.
+// The unread count of the next shoutbox poll (ShoutboxEventUserMessage) is zero afterwards.
+func MessagesMarkAllRead(c *Connection) error {
+	if err := c.assureLogin(); err != nil {
+		return err
+	}
+
+	return messagesAction(c, url.Values{"action": {"markallread"}})
+}
+
+// Mark a single private message as read
+func MessageMarkRead(c *Connection, id int64) error {
+	if err := c.assureLogin(); err != nil {
+		return err
+	}
+
+	data := url.Values{}
+	data.Set("action", "moveordel")
+	data.Set("markread", "1")
+	data.Add("messages[]", fmt.Sprintf("%d", id))
+
+	return messagesAction(c, data)
+}
+
+func messagesAction(c *Connection, data url.Values) error {
+	resp, err := c.postForm(c.buildUrl("messages.php", nil), data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if c.dryRun {
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return newRequestError(resp, errors.New("message not found"))
+	}
+	if ok, err := parseAjaxResult(body); !ok {
+		return newRequestError(resp, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMailbox(t *testing.T) {
+	pages := map[string][]byte{"in": readFixture(t, "messages_in.html"), "out": readFixture(t, "messages_out.html")}
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Query().Get("folder")]
+		if r.URL.Path != "/messages.php" || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(page)
+	}))
+	c.SetLocation(time.UTC)
+
+	inbox, err := Inbox(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Message{
+		{812, "Re: Dein Upload", "alice", 10, time.Date(2018, 3, 14, 12, 0, 0, 0, time.UTC), true},
+		{805, "Willkommen im Irrenhaus", "staff", 1, time.Date(2018, 3, 1, 8, 30, 0, 0, time.UTC), false},
+	}
+	if fmt.Sprint(inbox) != fmt.Sprint(want) {
+		t.Errorf("got inbox %v, want %v", inbox, want)
+	}
+
+	outbox, err := Outbox(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Message{{811, "Frage zu Newest.Release.2018", "alice", 10, time.Date(2018, 3, 14, 11, 45, 0, 0, time.UTC), false}}
+	if fmt.Sprint(outbox) != fmt.Sprint(want) {
+		t.Errorf("got outbox %v, want %v", outbox, want)
+	}
+}
+
+func TestMessagesMarkRead(t *testing.T) {
+	posted := make([]url.Values, 0)
+	answer := ""
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/messages.php" || r.Method != "POST" {
+			http.NotFound(w, r)
+			return
+		}
+		r.ParseForm()
+		posted = append(posted, r.PostForm)
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write([]byte(answer))
+	}))
+
+	if err := MessagesMarkAllRead(c); err != nil {
+		t.Error(err)
+	}
+	if err := MessageMarkRead(c, 812); err != nil {
+		t.Error(err)
+	}
+	want := []url.Values{
+		{"action": {"markallread"}},
+		{"action": {"moveordel"}, "markread": {"1"}, "messages[]": {"812"}},
+	}
+	if fmt.Sprint(posted) != fmt.Sprint(want) {
+		t.Errorf("posted %v, want %v", posted, want)
+	}
+
+	answer = "<html><body><span>Fehler</span> Nachricht nicht gefunden</body></html>"
+	if err := MessageMarkRead(c, 999); !errors.Is(err, ErrSiteError) {
+		t.Errorf("err = %v, want ErrSiteError", err)
+	}
+
+	// nothing is posted in dry-run mode
+	c.SetDryRun(true)
+	if err := MessagesMarkAllRead(c); err != nil {
+		t.Error(err)
+	}
+	if len(posted) != 3 {
+		t.Errorf("posted %d times, want 3", len(posted))
+	}
+}
@@ -0,0 +1,413 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+
+package irrenhaus_api
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The parsed content of a .torrent file
+type TorrentMeta struct {
+	InfoHash    string
+	Name        string
+	Announce    string
+	PieceLength int64
+	Size        uint64
+	Files       []TorrentFile
+	// the tool that created the torrent ("created by"), empty if not set
+	CreatedBy string
+	// "creation date", zero if not set
+	CreatedAt time.Time
+}
+
+// Parse the bencoded .torrent data and compute its info hash (lower case hex)
+func ParseTorrentMeta(data []byte) (*TorrentMeta, error) {
+	value, end, err := bdecode(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if end != len(data) {
+		return nil, errors.New("trailing data after torrent meta")
+	}
+	root, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("torrent meta is not a dictionary")
+	}
+
+	infoStart, infoEnd, err := bdictValueSpan(data, "info")
+	if err != nil {
+		return nil, err
+	}
+	info, ok := root["info"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("torrent meta has no info dictionary")
+	}
+
+	hash := sha1.Sum(data[infoStart:infoEnd])
+	meta := &TorrentMeta{InfoHash: hex.EncodeToString(hash[:])}
+	meta.Announce, _ = root["announce"].(string)
+	meta.CreatedBy, _ = root["created by"].(string)
+	if created, ok := root["creation date"].(int64); ok && created > 0 {
+		meta.CreatedAt = time.Unix(created, 0)
+	}
+	meta.Name, _ = info["name"].(string)
+	meta.PieceLength, _ = info["piece length"].(int64)
+
+	if length, ok := info["length"].(int64); ok {
+		// single file torrent
+		meta.Size = uint64(length)
+		meta.Files = []TorrentFile{{Name: meta.Name, Size: uint64(length)}}
+		return meta, nil
+	}
+
+	files, _ := info["files"].([]interface{})
+	for _, f := range files {
+		file, ok := f.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid file entry in torrent meta")
+		}
+		length, _ := file["length"].(int64)
+		parts := make([]string, 0)
+		elements, _ := file["path"].([]interface{})
+		for _, e := range elements {
+			if p, ok := e.(string); ok {
+				parts = append(parts, p)
+			}
+		}
+		meta.Files = append(meta.Files, TorrentFile{Name: path.Join(parts...), Size: uint64(length)})
+		meta.Size += uint64(length)
+	}
+
+	return meta, nil
+}
+
+// Maximum nesting of lists and dictionaries, real .torrent files need only a few levels
+const bdecodeMaxDepth = 64
+
+// Create the bencoded .torrent data for the given files and directories, e.g. to upload it with NewUpload.
+//
+// A single file creates a single file torrent, otherwise a multi file torrent is created.
+// The name of a multi file torrent is the directory name if a single directory is given,
+// else the name of the directory containing the first path.
+func CreateTorrentMeta(paths []string, announce string, pieceLength int64) ([]byte, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no files given")
+	}
+	if pieceLength <= 0 {
+		return nil, errors.New("invalid piece length")
+	}
+
+	type metaFile struct {
+		path  string
+		parts []string
+		size  int64
+	}
+	files := make([]metaFile, 0)
+
+	root := filepath.Dir(filepath.Clean(paths[0]))
+	first, err := os.Stat(paths[0])
+	if err != nil {
+		return nil, err
+	}
+	singleFile := len(paths) == 1 && !first.IsDir()
+	if len(paths) == 1 && first.IsDir() {
+		root = filepath.Clean(paths[0])
+	}
+
+	for _, p := range paths {
+		err := filepath.Walk(p, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(root, file)
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(rel, "..") {
+				return fmt.Errorf("%s is not below %s", file, root)
+			}
+			files = append(files, metaFile{path: file, parts: strings.Split(filepath.ToSlash(rel), "/"), size: info.Size()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no files found")
+	}
+
+	// the pieces span the file boundaries
+	pieces := &bytes.Buffer{}
+	piece := sha1.New()
+	pieceFill := int64(0)
+	for _, f := range files {
+		fh, err := os.Open(f.path)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			n, err := io.CopyN(piece, fh, pieceLength-pieceFill)
+			pieceFill += n
+			if pieceFill == pieceLength {
+				pieces.Write(piece.Sum(nil))
+				piece.Reset()
+				pieceFill = 0
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fh.Close()
+				return nil, err
+			}
+		}
+		fh.Close()
+	}
+	if pieceFill > 0 {
+		pieces.Write(piece.Sum(nil))
+	}
+
+	info := map[string]interface{}{
+		"piece length": pieceLength,
+		"pieces":       pieces.String(),
+	}
+	if singleFile {
+		info["name"] = filepath.Base(files[0].path)
+		info["length"] = files[0].size
+	} else {
+		info["name"] = filepath.Base(root)
+		list := make([]interface{}, 0, len(files))
+		for _, f := range files {
+			parts := make([]interface{}, 0, len(f.parts))
+			for _, part := range f.parts {
+				parts = append(parts, part)
+			}
+			list = append(list, map[string]interface{}{"length": f.size, "path": parts})
+		}
+		info["files"] = list
+	}
+
+	meta := map[string]interface{}{"info": info}
+	if announce != "" {
+		meta["announce"] = announce
+	}
+
+	buf := &bytes.Buffer{}
+	if err := bencode(buf, meta); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Already bencoded data, written as is
+type rawBencode []byte
+
+// Encode the value (integer, string, list or dictionary) as bencode
+func bencode(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case rawBencode:
+		buf.Write(v)
+	case int:
+		fmt.Fprintf(buf, "i%de", v)
+	case int64:
+		fmt.Fprintf(buf, "i%de", v)
+	case string:
+		fmt.Fprintf(buf, "%d:%s", len(v), v)
+	case []interface{}:
+		buf.WriteByte('l')
+		for _, e := range v {
+			if err := bencode(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		// keys have to be sorted
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, k := range keys {
+			bencode(buf, k)
+			if err := bencode(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("can not bencode %T", value)
+	}
+
+	return nil
+}
+
+// Replace the announce url of a .torrent file, e.g. with one containing the own passkey.
+// The announce-list is removed, the info dictionary is kept byte for byte so the info hash does not change.
+func RewriteAnnounce(data []byte, announce string) ([]byte, error) {
+	value, _, err := bdecode(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("torrent meta is not a dictionary")
+	}
+	start, end, err := bdictValueSpan(data, "info")
+	if err != nil {
+		return nil, err
+	}
+
+	dict["info"] = rawBencode(data[start:end])
+	dict["announce"] = announce
+	delete(dict, "announce-list")
+
+	buf := &bytes.Buffer{}
+	if err := bencode(buf, dict); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode the bencoded value starting at pos.
+// Returns the value (int64, string, []interface{} or map[string]interface{}) and the position after it.
+func bdecode(data []byte, pos int) (interface{}, int, error) {
+	return bdecodeValue(data, pos, 0)
+}
+
+func bdecodeValue(data []byte, pos int, depth int) (interface{}, int, error) {
+	if pos >= len(data) {
+		return nil, pos, errors.New("unexpected end of bencode data")
+	}
+	if depth > bdecodeMaxDepth {
+		return nil, pos, errors.New("bencode data nested too deeply")
+	}
+
+	switch {
+	case data[pos] == 'i':
+		end := bytes.IndexByte(data[pos:], 'e')
+		if end < 0 {
+			return nil, pos, errors.New("unterminated bencode integer")
+		}
+		i, err := strconv.ParseInt(string(data[pos+1:pos+end]), 10, 64)
+		if err != nil {
+			return nil, pos, err
+		}
+		return i, pos + end + 1, nil
+	case data[pos] == 'l':
+		list := make([]interface{}, 0)
+		pos++
+		for pos < len(data) && data[pos] != 'e' {
+			value, end, err := bdecodeValue(data, pos, depth+1)
+			if err != nil {
+				return nil, pos, err
+			}
+			list = append(list, value)
+			pos = end
+		}
+		if pos >= len(data) {
+			return nil, pos, errors.New("unterminated bencode list")
+		}
+		return list, pos + 1, nil
+	case data[pos] == 'd':
+		dict := make(map[string]interface{})
+		pos++
+		for pos < len(data) && data[pos] != 'e' {
+			key, end, err := bdecodeValue(data, pos, depth+1)
+			if err != nil {
+				return nil, pos, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, pos, errors.New("bencode dictionary key is not a string")
+			}
+			value, end, err := bdecodeValue(data, end, depth+1)
+			if err != nil {
+				return nil, pos, err
+			}
+			dict[k] = value
+			pos = end
+		}
+		if pos >= len(data) {
+			return nil, pos, errors.New("unterminated bencode dictionary")
+		}
+		return dict, pos + 1, nil
+	case data[pos] >= '0' && data[pos] <= '9':
+		colon := bytes.IndexByte(data[pos:], ':')
+		if colon < 0 {
+			return nil, pos, errors.New("invalid bencode string")
+		}
+		length, err := strconv.Atoi(string(data[pos : pos+colon]))
+		if err != nil {
+			return nil, pos, err
+		}
+		start := pos + colon + 1
+		// compare without adding, a huge length would overflow
+		if length < 0 || length > len(data)-start {
+			return nil, pos, errors.New("bencode string exceeds data")
+		}
+		return string(data[start : start+length]), start + length, nil
+	}
+
+	return nil, pos, errors.New("invalid bencode data")
+}
+
+// Find the raw bytes of a value in the top level dictionary
+func bdictValueSpan(data []byte, key string) (int, int, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return 0, 0, errors.New("torrent meta is not a dictionary")
+	}
+
+	pos := 1
+	for pos < len(data) && data[pos] != 'e' {
+		k, end, err := bdecode(data, pos)
+		if err != nil {
+			return 0, 0, err
+		}
+		_, valueEnd, err := bdecode(data, end)
+		if err != nil {
+			return 0, 0, err
+		}
+		if k == key {
+			return end, valueEnd, nil
+		}
+		pos = valueEnd
+	}
+
+	return 0, 0, errors.New("key " + key + " not found")
+}
@@ -0,0 +1,312 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTorrentInfo = "d6:lengthi5e4:name5:a.txt12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae"
+
+var testTorrent = []byte("d8:announce23:http://tracker/announce4:info" + testTorrentInfo + "e")
+
+func testTorrentHash() string {
+	hash := sha1.Sum([]byte(testTorrentInfo))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestParseTorrentMeta(t *testing.T) {
+	meta, err := ParseTorrentMeta(testTorrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.InfoHash != testTorrentHash() {
+		t.Errorf("InfoHash = %s, want %s", meta.InfoHash, testTorrentHash())
+	}
+	if meta.Name != "a.txt" || meta.Size != 5 || meta.Announce != "http://tracker/announce" {
+		t.Errorf("unexpected meta %+v", meta)
+	}
+}
+
+func TestParseTorrentMetaCreated(t *testing.T) {
+	data := []byte("d8:announce23:http://tracker/announce10:created by13:mktorrent 1.113:creation datei1520885700e4:info" + testTorrentInfo + "e")
+	meta, err := ParseTorrentMeta(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.CreatedBy != "mktorrent 1.1" || !meta.CreatedAt.Equal(time.Unix(1520885700, 0)) {
+		t.Errorf("created by %q at %v, want mktorrent 1.1 at %v", meta.CreatedBy, meta.CreatedAt, time.Unix(1520885700, 0))
+	}
+	// the keys are not part of the info hash
+	if meta.InfoHash != testTorrentHash() {
+		t.Errorf("InfoHash = %s, want %s", meta.InfoHash, testTorrentHash())
+	}
+
+	meta, err = ParseTorrentMeta(testTorrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.CreatedBy != "" || !meta.CreatedAt.IsZero() {
+		t.Errorf("created by %q at %v without the keys, want empty values", meta.CreatedBy, meta.CreatedAt)
+	}
+}
+
+func TestParseTorrentMetaMalformed(t *testing.T) {
+	inputs := map[string]string{
+		"empty":              "",
+		"overflowing length": "d9223372036854775807:xe",
+		"long string":        "d4:infod4:name100:abce",
+		"negative length":    "d-1:xe",
+		"unterminated dict":  "d4:infod",
+		"unterminated int":   "d1:xi12",
+		"invalid integer":    "d1:xiabce",
+		"no dictionary":      "l1:xe",
+		"trailing data":      "d1:xi1eexyz",
+		"deep nesting":       "d1:x" + strings.Repeat("l", 10000) + strings.Repeat("e", 10000) + "e",
+	}
+	for name, input := range inputs {
+		if _, err := ParseTorrentMeta([]byte(input)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func torrentHandler(data []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/download.php" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-bittorrent")
+		w.Header().Set("Content-Disposition", `attachment; filename="a.txt.torrent"`)
+		w.Write(data)
+	})
+}
+
+func TestDownloadTorrentVerified(t *testing.T) {
+	c := newTestConnection(t, torrentHandler(testTorrent))
+
+	data, filename, err := DownloadTorrentVerified(c, 1, strings.ToUpper(testTorrentHash()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(testTorrent) || filename != "a.txt.torrent" {
+		t.Errorf("unexpected download %q %q", data, filename)
+	}
+
+	_, _, err = DownloadTorrentVerified(c, 1, strings.Repeat("0", 40))
+	if !errors.Is(err, ErrInfoHashMismatch) {
+		t.Errorf("err = %v, want ErrInfoHashMismatch", err)
+	}
+
+	// no expected hash skips the check
+	if _, _, err := DownloadTorrentVerified(c, 1, ""); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDownloadTorrentVerifiedMalformed(t *testing.T) {
+	c := newTestConnection(t, torrentHandler([]byte("d9223372036854775807:xe")))
+
+	if _, _, err := DownloadTorrentVerified(c, 1, testTorrentHash()); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestCreateTorrentMetaSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := CreateTorrentMeta([]string{file}, "http://tracker/announce", 16384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ParseTorrentMeta(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	piece := sha1.Sum([]byte("hello"))
+	info := "d6:lengthi5e4:name5:a.txt12:piece lengthi16384e6:pieces20:" + string(piece[:]) + "e"
+	hash := sha1.Sum([]byte(info))
+	if meta.InfoHash != hex.EncodeToString(hash[:]) {
+		t.Errorf("InfoHash = %s, want %s", meta.InfoHash, hex.EncodeToString(hash[:]))
+	}
+	if meta.Name != "a.txt" || meta.Size != 5 || meta.PieceLength != 16384 || meta.Announce != "http://tracker/announce" {
+		t.Errorf("unexpected meta %+v", meta)
+	}
+}
+
+func TestCreateTorrentMetaDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "release")
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := map[string]string{"a.nfo": "nfo", "sub/b.bin": "0123456789abcdefghij"}
+	for name, text := range content {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := CreateTorrentMeta([]string{dir}, "", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := CreateTorrentMeta([]string{dir}, "", 16)
+	if err != nil || !bytes.Equal(data, again) {
+		t.Fatalf("the meta differs when creating it again (%v)", err)
+	}
+	meta, err := ParseTorrentMeta(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pieces span the file boundary
+	all := "nfo0123456789abcdefghij"
+	first, second := sha1.Sum([]byte(all[:16])), sha1.Sum([]byte(all[16:]))
+	info := "d5:filesld6:lengthi3e4:pathl5:a.nfoeed6:lengthi20e4:pathl3:sub5:b.bineee4:name7:release" +
+		"12:piece lengthi16e6:pieces40:" + string(first[:]) + string(second[:]) + "e"
+	hash := sha1.Sum([]byte(info))
+	if meta.InfoHash != hex.EncodeToString(hash[:]) {
+		t.Errorf("InfoHash = %s, want %s", meta.InfoHash, hex.EncodeToString(hash[:]))
+	}
+	if meta.Name != "release" || meta.Size != 23 || len(meta.Files) != 2 ||
+		meta.Files[0] != (TorrentFile{Name: "a.nfo", Size: 3}) || meta.Files[1] != (TorrentFile{Name: "sub/b.bin", Size: 20}) {
+		t.Errorf("unexpected meta %+v", meta)
+	}
+}
+
+func TestDownloadTorrentRatioTooLow(t *testing.T) {
+	c := newTestConnection(t, pageHandler(readFixture(t, "download_ratio.html")))
+
+	_, _, err := DownloadTorrent(c, 205)
+	if !errors.Is(err, ErrRatioTooLow) {
+		t.Fatalf("err = %v, want ErrRatioTooLow", err)
+	}
+	if !strings.Contains(err.Error(), "Deine Ratio ist zu niedrig.") {
+		t.Errorf("err = %v, want it to contain the message of the page", err)
+	}
+	var requestError *RequestError
+	if !errors.As(err, &requestError) || !strings.HasSuffix(requestError.URL, "/download.php?torrent=205") {
+		t.Errorf("err = %#v, want a RequestError of the download", err)
+	}
+}
+
+func TestGrabToWatchDir(t *testing.T) {
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-bittorrent")
+		w.Header().Set("Content-Disposition", `attachment; filename="Some: Release/2018?"`)
+		w.Write(testTorrent)
+	}))
+	dir := t.TempDir()
+
+	path, err := GrabToWatchDir(c, 205, dir, "https://other.example/announce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "Some_ Release_2018_.torrent"); path != want {
+		t.Errorf("path = %q, want %q", path, want)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ParseTorrentMeta(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Announce != "https://other.example/announce" || meta.InfoHash != testTorrentHash() {
+		t.Errorf("got announce %q and hash %s, want the new announce and the same hash", meta.Announce, meta.InfoHash)
+	}
+
+	// without an announce the file is written as downloaded
+	path, err = GrabToWatchDir(c, 205, dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != string(testTorrent) {
+		t.Errorf("got %q, want the downloaded torrent", data)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "Some_ Release_2018_.torrent" {
+		names := make([]string, 0)
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		t.Errorf("watch dir contains %v, want only the torrent", names)
+	}
+
+	if _, err := GrabToWatchDir(c, 205, filepath.Join(dir, "missing"), ""); err == nil {
+		t.Error("grabbed into a missing directory")
+	}
+}
+
+func TestDownloadTorrentMeta(t *testing.T) {
+	details := readFixture(t, "details.html")
+	tests := []struct {
+		details []byte
+		err     error
+	}{
+		{bytes.Replace(details, []byte("0123456789ABCDEF0123456789ABCDEF01234567"), []byte(strings.ToUpper(testTorrentHash())), 1), nil},
+		{details, ErrInfoHashMismatch},
+		// no hash on the details page skips the check
+		{readFixture(t, "details_hash_empty.html"), nil},
+	}
+	for i, test := range tests {
+		download := torrentHandler(testTorrent)
+		page := test.details
+		c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/details.php" {
+				w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+				w.Write(page)
+				return
+			}
+			download.ServeHTTP(w, r)
+		}))
+
+		meta, data, filename, err := DownloadTorrentMeta(c, 205)
+		if !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+			t.Errorf("%d: err = %v, want %v", i, err, test.err)
+			continue
+		}
+		if test.err != nil {
+			continue
+		}
+		if meta.InfoHash != testTorrentHash() || string(data) != string(testTorrent) || filename != "a.txt.torrent" {
+			t.Errorf("%d: unexpected download %s %q %q", i, meta.InfoHash, data, filename)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// A torrent snatched by the current user, from the snatch history of the profile.
+// SeedTime is the time seeded so far, SeedTimeRequired the minimum seed time
+// against hit and run, zero if the torrent has none.
+type MySnatch struct {
+	TorrentId        int64
+	Name             string
+	Uploaded         uint64
+	Downloaded       uint64
+	Ratio            float64
+	Completed        time.Time
+	Stopped          time.Time
+	Seeding          bool
+	SeedTime         time.Duration
+	SeedTimeRequired time.Duration
+}
+
+// A snatched torrent which is not seeded anymore, but still owes seed time
+type SeedObligation struct {
+	TorrentId int64
+	Name      string
+	Stopped   time.Time
+	Remaining time.Duration
+}
+
+// Get all torrents snatched by the current user, crawling all pages of the snatch history
+func MySnatches(c *Connection) ([]MySnatch, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	snatches := make([]MySnatch, 0)
+	data := url.Values{"action": {"viewsnatches"}, "id": {fmt.Sprintf("%d", c.GetCookies().Uid)}}
+	for page := int64(0); ; page++ {
+		data.Set("page", fmt.Sprintf("%d", page))
+		resp, err := c.get(c.buildUrl("userhistory.php", data))
+		if err != nil {
+			return nil, err
+		}
+		body, err := readLatin1(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		debugRequest(resp, string(body))
+
+		if resp.StatusCode == 404 {
+			return nil, newRequestError(resp, ErrNotFound)
+		}
+
+		pageSnatches, maxpage, err := parseMySnatches(bytes.NewReader(body), c.getLocation())
+		if err != nil {
+			return nil, err
+		}
+		snatches = append(snatches, pageSnatches...)
+
+		if page >= maxpage {
+			break
+		}
+	}
+
+	return snatches, nil
+}
+
+// Search the torrents snatched by the current user by name.
+// The needle is split at white space, a torrent matches if its name contains every word,
+// ignoring case and the dots or underscores between the words of a release name
+// ("newest release" finds "Newest.Release.2018"). An empty needle matches all snatches.
+// Only Id and Name of the entries are set.
+func SearchMySnatches(c *Connection, needle string) ([]TorrentEntry, error) {
+	snatches, err := MySnatches(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return searchSnatches(snatches, needle), nil
+}
+
+func searchSnatches(snatches []MySnatch, needle string) []TorrentEntry {
+	normalize := strings.NewReplacer(".", " ", "_", " ")
+	words := strings.Fields(strings.ToLower(normalize.Replace(needle)))
+	entries := make([]TorrentEntry, 0)
+	for _, snatch := range snatches {
+		name := strings.ToLower(normalize.Replace(snatch.Name))
+		matches := true
+		for _, word := range words {
+			if !strings.Contains(name, word) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			entries = append(entries, TorrentEntry{Id: int(snatch.TorrentId), Name: snatch.Name})
+		}
+	}
+
+	return entries
+}
+
+// List the snatched torrents which are not seeded anymore and still owe seed time,
+// the least remaining seed time first
+func SeedObligations(c *Connection) ([]SeedObligation, error) {
+	snatches, err := MySnatches(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return seedObligations(snatches), nil
+}
+
+func seedObligations(snatches []MySnatch) []SeedObligation {
+	obligations := make([]SeedObligation, 0)
+	for _, snatch := range snatches {
+		if snatch.Seeding || snatch.SeedTime >= snatch.SeedTimeRequired {
+			continue
+		}
+		obligations = append(obligations, SeedObligation{
+			TorrentId: snatch.TorrentId,
+			Name:      snatch.Name,
+			Stopped:   snatch.Stopped,
+			Remaining: snatch.SeedTimeRequired - snatch.SeedTime,
+		})
+	}
+	sort.SliceStable(obligations, func(i, j int) bool {
+		return obligations[i].Remaining < obligations[j].Remaining
+	})
+
+	return obligations
+}
+
+// Header labels (lower case prefixes) of the snatch history columns
+var mySnatchesLabels = map[string][]string{
+	"name":       {"name", "torrent"},
+	"uploaded":   {"hochgeladen"},
+	"downloaded": {"heruntergeladen"},
+	"ratio":      {"ratio"},
+	"completed":  {"fertiggestellt"},
+	"stopped":    {"gestoppt", "status"},
+	"seedtime":   {"seedzeit"},
+	"required":   {"mindestseedzeit", "h&r"},
+}
+
+// Parse a page of the snatch history, returns the snatches and the highest page number of the pager
+func parseMySnatches(reader io.Reader, location *time.Location) ([]MySnatch, int64, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	snatches := make([]MySnatch, 0)
+	ire, _ := regexp.Compile("details\\.php\\?id=(\\d+)")
+	doc.Find("table.tableinborder").Each(func(i int, table *goquery.Selection) {
+		columns := make(map[string]int)
+		table.Find("tr").Each(func(i int, s *goquery.Selection) {
+			tds := s.Find("td")
+			if i == 0 {
+				tds.Each(func(col int, td *goquery.Selection) {
+					label := strings.ToLower(strings.TrimSpace(td.Text()))
+					for field, labels := range mySnatchesLabels {
+						for _, l := range labels {
+							if strings.HasPrefix(label, l) {
+								columns[field] = col
+							}
+						}
+					}
+				})
+				return
+			}
+			if _, ok := columns["name"]; !ok {
+				return
+			}
+
+			snatch := MySnatch{Completed: time.Unix(0, 0), Stopped: time.Unix(0, 0)}
+			link := tds.Eq(columns["name"]).Find("a[href^='details.php']").First()
+			href, _ := link.Attr("href")
+			if !ire.MatchString(href) {
+				return
+			}
+			snatch.TorrentId, _ = strconv.ParseInt(ire.FindStringSubmatch(href)[1], 10, 64)
+			snatch.Name = link.AttrOr("title", strings.TrimSpace(link.Text()))
+
+			if col, ok := columns["uploaded"]; ok {
+				snatch.Uploaded = stringToDatasize(strings.TrimSpace(tds.Eq(col).Text()))
+			}
+			if col, ok := columns["downloaded"]; ok {
+				snatch.Downloaded = stringToDatasize(strings.TrimSpace(tds.Eq(col).Text()))
+			}
+			if col, ok := columns["ratio"]; ok {
+				ratio := strings.TrimSpace(tds.Eq(col).Text())
+				if ratio == "Inf." {
+					snatch.Ratio = -1.0
+				} else if temp, err := strconv.ParseFloat(ratio, 64); err == nil {
+					snatch.Ratio = temp
+				}
+			}
+			if col, ok := columns["completed"]; ok {
+				date, err := time.ParseInLocation("2006-01-02 15:04:05", strings.TrimSpace(tds.Eq(col).Text()), location)
+				if err == nil {
+					snatch.Completed = date
+				}
+			}
+			if col, ok := columns["stopped"]; ok {
+				text := strings.TrimSpace(tds.Eq(col).Text())
+				if text == "Seedet im Moment" {
+					snatch.Seeding = true
+				} else if date, err := time.ParseInLocation("2006-01-02 15:04:05", text, location); err == nil {
+					snatch.Stopped = date
+				}
+			}
+			if col, ok := columns["seedtime"]; ok {
+				snatch.SeedTime = parseSeedTime(tds.Eq(col).Text())
+			}
+			if col, ok := columns["required"]; ok {
+				snatch.SeedTimeRequired = parseSeedTime(tds.Eq(col).Text())
+			}
+
+			snatches = append(snatches, snatch)
+		})
+	})
+
+	return snatches, parseTorrentListMaxPage(doc), nil
+}
+
+// Parse a seed time shown as hours and minutes ("36:15"), zero if there is none ("-")
+func parseSeedTime(text string) time.Duration {
+	re, _ := regexp.Compile("(\\d+):(\\d{2})")
+	m := re.FindStringSubmatch(text)
+	if m == nil {
+		return 0
+	}
+	hours, _ := strconv.ParseInt(m[1], 10, 64)
+	minutes, _ := strconv.ParseInt(m[2], 10, 64)
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+}
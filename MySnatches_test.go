@@ -0,0 +1,129 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Serve the pages of the snatch history of user 42
+func mySnatchesHandler(t *testing.T) http.Handler {
+	pages := [][]byte{readFixture(t, "mysnatches_0.html"), readFixture(t, "mysnatches_1.html")}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/userhistory.php" || query.Get("action") != "viewsnatches" || query.Get("id") != "42" {
+			http.NotFound(w, r)
+			return
+		}
+		var page int
+		fmt.Sscan(query.Get("page"), &page)
+		if page >= len(pages) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(pages[page])
+	})
+}
+
+func TestMySnatches(t *testing.T) {
+	c := newTestConnection(t, mySnatchesHandler(t))
+	c.SetLocation(time.UTC)
+
+	snatches, err := MySnatches(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snatches) != 5 {
+		t.Fatalf("got %d snatches, want 5", len(snatches))
+	}
+
+	first := snatches[0]
+	if first.TorrentId != 205 || first.Name != "Newest.Release.2018" || !first.Seeding {
+		t.Errorf("first snatch = %+v", first)
+	}
+	if first.SeedTime != 40*time.Hour || first.SeedTimeRequired != 72*time.Hour {
+		t.Errorf("seed time = %v of %v, want 40h of 72h", first.SeedTime, first.SeedTimeRequired)
+	}
+	if first.Ratio != 2 || first.Uploaded != 3*1024*1024*1024 {
+		t.Errorf("ratio = %v, uploaded = %d", first.Ratio, first.Uploaded)
+	}
+	if want := time.Date(2018, 3, 11, 9, 0, 0, 0, time.UTC); !snatches[1].Stopped.Equal(want) || snatches[1].Seeding {
+		t.Errorf("second snatch stopped at %v (seeding %v), want %v", snatches[1].Stopped, snatches[1].Seeding, want)
+	}
+	if snatches[2].SeedTimeRequired != 0 {
+		t.Errorf("snatch without hit and run requires %v", snatches[2].SeedTimeRequired)
+	}
+}
+
+func TestSeedObligations(t *testing.T) {
+	c := newTestConnection(t, mySnatchesHandler(t))
+
+	obligations, err := SeedObligations(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// seeding, without hit and run or with enough seed time don't count
+	want := []struct {
+		id        int64
+		remaining time.Duration
+	}{
+		{150, 90 * time.Minute},
+		{204, 48 * time.Hour},
+	}
+	if len(obligations) != len(want) {
+		t.Fatalf("got %d obligations, want %d: %+v", len(obligations), len(want), obligations)
+	}
+	for i, w := range want {
+		if obligations[i].TorrentId != w.id || obligations[i].Remaining != w.remaining {
+			t.Errorf("obligation %d = %d with %v remaining, want %d with %v", i, obligations[i].TorrentId, obligations[i].Remaining, w.id, w.remaining)
+		}
+	}
+}
+
+func TestSearchMySnatches(t *testing.T) {
+	c := newTestConnection(t, mySnatchesHandler(t))
+
+	tests := []struct {
+		needle string
+		want   []int
+	}{
+		{"release", []int{205, 204}},
+		{"NEWEST release", []int{205}},
+		{"older.release", []int{204}},
+		{"2017", []int{180, 150, 120}},
+		{"release 2017", nil},
+		{"", []int{205, 204, 180, 150, 120}},
+	}
+	for _, test := range tests {
+		entries, err := SearchMySnatches(c, test.needle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]int, 0)
+		for _, entry := range entries {
+			ids = append(ids, entry.Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(append([]int{}, test.want...)) {
+			t.Errorf("SearchMySnatches(%q) = %v, want %v", test.needle, ids, test.want)
+		}
+	}
+}
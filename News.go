@@ -0,0 +1,111 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+
+package irrenhaus_api
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type NewsItem struct {
+	Title  string
+	Body   string
+	Date   time.Time
+	Author string
+}
+
+// Get the site announcements from the index page, newest first
+func News(c *Connection) ([]NewsItem, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.get(c.buildUrl("index.php", nil))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	debugRequest(resp, string(body))
+
+	return parseNews(bytes.NewReader(body), c.url, c.getLocation())
+}
+
+func parseNews(reader io.Reader, baseUrl string, location *time.Location) ([]NewsItem, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	news := make([]NewsItem, 0)
+	// the title row looks like "2018-01-02 15:04:05 - Title"
+	re, _ := regexp.Compile("^(\\d{4}-\\d{2}-\\d{2} \\d{2}:\\d{2}:\\d{2})\\s*-?\\s*(.*)$")
+
+	doc.Find("div.blockinborder").Each(func(i int, s *goquery.Selection) {
+		if !strings.HasPrefix(strings.TrimSpace(s.Find("div.centeredtitle").First().Text()), "News") {
+			return
+		}
+
+		s.Find("table.tableinborder").Each(func(i int, s *goquery.Selection) {
+			trs := s.Find("tr")
+			if len(trs.Nodes) < 2 {
+				return
+			}
+
+			item := NewsItem{}
+			head := trs.Eq(0)
+			title := strings.TrimSpace(head.Find("td").First().Text())
+			if re.MatchString(title) {
+				m := re.FindStringSubmatch(title)
+				date, err := time.ParseInLocation("2006-01-02 15:04:05", m[1], location)
+				if err != nil {
+					debugLog("[News]", err.Error())
+				}
+				item.Date = date
+				title = m[2]
+			}
+			item.Title = title
+			if len(head.Find("a[href*=userdetails]").Nodes) > 0 {
+				item.Author, _ = parseUserLink(head)
+			}
+
+			rawBody, err := trs.Eq(1).Find("td").First().Html()
+			if err == nil {
+				item.Body = ShoutboxStrip(rawBody, baseUrl)
+			}
+
+			news = append(news, item)
+		})
+	})
+
+	sort.SliceStable(news, func(i, j int) bool {
+		return news[i].Date.After(news[j].Date)
+	})
+
+	return news, nil
+}
@@ -0,0 +1,115 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// The profile of a user. Class is the class as shown on the site ("Power User"),
+// Rank the parsed class for comparisons, ClassUnknown if the name is unknown.
+type UserProfile struct {
+	Id         int64
+	Name       string
+	Class      string
+	Rank       Class
+	Uploaded   uint64
+	Downloaded uint64
+	Joined     time.Time
+}
+
+// Get the profile of a user, returns ErrNotFound if the user does not exist
+func Profile(c *Connection, userId int64) (*UserProfile, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.get(c.buildUrl("userdetails.php", url.Values{"id": {fmt.Sprintf("%d", userId)}}))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return nil, newRequestError(resp, ErrNotFound)
+	}
+
+	profile, err := parseProfile(bytes.NewReader(body), c.getLocation())
+	if err != nil {
+		return nil, err
+	}
+	profile.Id = userId
+
+	return profile, nil
+}
+
+// Parse the profile page, the user name is the title of the block ("Profil von Name")
+// and the values are rows of label and value
+func parseProfile(reader io.Reader, location *time.Location) (*UserProfile, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := UserProfile{}
+	var trs *goquery.Selection
+	doc.Find("div.blockinborder").EachWithBreak(func(i int, node *goquery.Selection) bool {
+		title := strings.TrimSpace(node.Find("div.centeredtitle b").Text())
+		if !strings.HasPrefix(title, "Profil von ") {
+			return true
+		}
+		profile.Name = strings.TrimPrefix(title, "Profil von ")
+		trs = node.Find("table.tableinborder tr")
+		return false
+	})
+	if trs == nil {
+		return nil, ErrNotFound
+	}
+
+	if td := findTdByLabel(trs, "Klasse", "Rang"); td != nil {
+		profile.Class = strings.TrimSpace(td.Text())
+		// unknown classes are kept as ClassUnknown
+		profile.Rank.Parse(profile.Class)
+	}
+	if td := findTdByLabel(trs, "Hochgeladen"); td != nil {
+		profile.Uploaded = stringToDatasize(strings.TrimSpace(td.Text()))
+	}
+	if td := findTdByLabel(trs, "Heruntergeladen"); td != nil {
+		profile.Downloaded = stringToDatasize(strings.TrimSpace(td.Text()))
+	}
+	if td := findTdByLabel(trs, "Beigetreten", "Registriert"); td != nil {
+		joined, err := time.ParseInLocation("2006-01-02 15:04:05", strings.TrimSpace(td.Text()), location)
+		if err == nil {
+			profile.Joined = joined
+		}
+	}
+
+	return &profile, nil
+}
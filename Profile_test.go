@@ -0,0 +1,54 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestProfile(t *testing.T) {
+	c := newTestConnection(t, pageHandler(readFixture(t, "userdetails.html")))
+	c.SetLocation(time.UTC)
+
+	profile, err := Profile(c, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Id != 10 || profile.Name != "alice" || profile.Class != "Power User" || profile.Rank != ClassPowerUser {
+		t.Errorf("profile = %+v", profile)
+	}
+	if profile.Rank < ClassPowerUser || profile.Rank >= ClassVIP {
+		t.Errorf("Rank %v is not comparable", profile.Rank)
+	}
+	if want := time.Date(2017, 1, 5, 12, 0, 0, 0, time.UTC); !profile.Joined.Equal(want) {
+		t.Errorf("Joined = %v, want %v", profile.Joined, want)
+	}
+	if profile.Uploaded != uint64(12.5*1024*1024*1024) {
+		t.Errorf("Uploaded = %d", profile.Uploaded)
+	}
+}
+
+func TestProfileNotFound(t *testing.T) {
+	c := newTestConnection(t, http.NotFoundHandler())
+	if _, err := Profile(c, 4711); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Profile: err = %v, want ErrNotFound", err)
+	}
+}
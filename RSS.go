@@ -0,0 +1,78 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"net/url"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Get the personal RSS feed link (including the secret key) from the settings page.
+// The link is cached on the connection. Returns ErrNotFound if RSS is not enabled for the account.
+func RSSLink(c *Connection) (string, error) {
+	if c.rssLink != "" {
+		return c.rssLink, nil
+	}
+	if err := c.assureLogin(); err != nil {
+		return "", err
+	}
+
+	resp, err := c.get(c.buildUrl("my.php", nil))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	debugRequest(resp, string(body))
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	link := ""
+	doc.Find("a[href*='rss.php'], input[value*='rss.php']").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		href, ok := s.Attr("href")
+		if !ok {
+			href, _ = s.Attr("value")
+		}
+		u, err := url.Parse(href)
+		if err != nil || u.RawQuery == "" {
+			// the link without key only leads to the settings
+			return true
+		}
+		base, err := url.Parse(c.buildUrl("my.php", nil))
+		if err != nil {
+			return true
+		}
+		link = base.ResolveReference(u).String()
+		return false
+	})
+	if link == "" {
+		return "", newRequestError(resp, ErrNotFound)
+	}
+
+	c.rssLink = link
+
+	return link, nil
+}
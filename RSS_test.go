@@ -0,0 +1,79 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Serve the settings page with a feed link of the session's account, and the logout
+func rssHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		if r.URL.Path == "/logout.php" {
+			w.Write([]byte("<html><body>Du bist jetzt ausgeloggt.</body></html>"))
+			return
+		}
+		pass := ""
+		if cookie, err := r.Cookie("pass"); err == nil {
+			pass = cookie.Value
+		}
+		fmt.Fprintf(w, `<html><body><a href="logout.php">Logout</a>
+<input type="text" value="rss.php?feed=dl&amp;passkey=%s" readonly></body></html>`, pass)
+	})
+}
+
+func TestRSSLinkLogout(t *testing.T) {
+	c := newTestConnection(t, rssHandler())
+
+	link, err := RSSLink(c)
+	if err != nil || !strings.HasSuffix(link, "/rss.php?feed=dl&passkey=pass") {
+		t.Fatalf("RSSLink = %q, %v", link, err)
+	}
+	if err := c.Logout(); err != nil {
+		t.Fatal(err)
+	}
+	if c.rssLink != "" {
+		t.Error("the feed link is kept after the logout")
+	}
+
+	c.SetCookies(Cookies{Uid: 43, Pass: "other", Passhash: "hash"})
+	link, err = RSSLink(c)
+	if err != nil || !strings.HasSuffix(link, "passkey=other") {
+		t.Errorf("RSSLink of the next account = %q, %v", link, err)
+	}
+}
+
+func TestRSSLinkRestoreSession(t *testing.T) {
+	c := newTestConnection(t, rssHandler())
+	if _, err := RSSLink(c); err != nil {
+		t.Fatal(err)
+	}
+
+	data := fmt.Sprintf(`{"Url":%q,"Username":"user","Cookies":{"Uid":42,"Pass":"restored","Passhash":"hash"}}`, c.url)
+	if err := c.RestoreSession([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	link, err := RSSLink(c)
+	if err != nil || !strings.HasSuffix(link, "passkey=restored") {
+		t.Errorf("RSSLink after RestoreSession = %q, %v", link, err)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+
+package irrenhaus_api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Report a torrent to the staff
+func ReportTorrent(c *Connection, torrentId int64, reason string) (bool, error) {
+	if err := c.assureLogin(); err != nil {
+		return false, err
+	}
+
+	// the report form carries the token which has to be sent back
+	query := url.Values{"type": {"torrent"}, "id": {fmt.Sprintf("%d", torrentId)}}
+	resp, err := c.get(c.buildUrl("report.php", query))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return false, newRequestError(resp, ErrTorrentNotFound)
+	}
+	if bytes.Contains(body, []byte("bereits gemeldet")) {
+		return false, newRequestError(resp, ErrAlreadyReported)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	data := parseHiddenFields(doc.Find("form").First())
+	data.Set("reason", reason)
+
+	resp, err = c.postForm(c.buildUrl("report.php", query), data)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if c.dryRun {
+		return true, nil
+	}
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return false, newRequestError(resp, ErrTorrentNotFound)
+	}
+	if bytes.Contains(body, []byte("bereits gemeldet")) {
+		return false, newRequestError(resp, ErrAlreadyReported)
+	}
+	if ok, err := parseAjaxResult(body); !ok {
+		return false, newRequestError(resp, err)
+	}
+	if conf := parseConfirmation(body, c.buildUrl("report.php", query)); conf != nil {
+		return Confirm(c, conf)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,80 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// Serve the report form and answer the report with the given page
+func reportHandler(t *testing.T, form, answer []byte, submitted *url.Values) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/report.php" || r.URL.Query().Get("id") != "205" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		if r.Method != "POST" {
+			w.Write(form)
+			return
+		}
+		r.ParseForm()
+		*submitted = r.PostForm
+		w.Write(answer)
+	})
+}
+
+func TestReportTorrent(t *testing.T) {
+	var submitted url.Values
+	c := newTestConnection(t, reportHandler(t, readFixture(t, "report_form.html"), readFixture(t, "report_done.html"), &submitted))
+
+	ok, err := ReportTorrent(c, 205, "Fake, das Archiv ist leer")
+	if err != nil || !ok {
+		t.Fatalf("ReportTorrent = %v, %v", ok, err)
+	}
+	if submitted.Get("token") != "a1b2c3" || submitted.Get("id") != "205" || submitted.Get("reason") != "Fake, das Archiv ist leer" {
+		t.Errorf("submitted = %v, want the token, the id and the reason", submitted)
+	}
+
+	if _, err := ReportTorrent(c, 4711, "tot"); !errors.Is(err, ErrTorrentNotFound) {
+		t.Errorf("err = %v, want ErrTorrentNotFound", err)
+	}
+}
+
+func TestReportTorrentAlreadyReported(t *testing.T) {
+	var submitted url.Values
+	already := readFixture(t, "report_already.html")
+
+	// already known when opening the form
+	c := newTestConnection(t, reportHandler(t, already, nil, &submitted))
+	if _, err := ReportTorrent(c, 205, "tot"); !errors.Is(err, ErrAlreadyReported) {
+		t.Errorf("err = %v, want ErrAlreadyReported", err)
+	}
+	if submitted != nil {
+		t.Error("the report was submitted")
+	}
+
+	// or only after submitting it
+	c = newTestConnection(t, reportHandler(t, readFixture(t, "report_form.html"), already, &submitted))
+	if _, err := ReportTorrent(c, 205, "tot"); !errors.Is(err, ErrAlreadyReported) {
+		t.Errorf("err = %v, want ErrAlreadyReported", err)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Repeat GET requests answered with 429 (Too Many Requests) or 503 (Service Unavailable)
+// up to retries times, disabled by default.
+// The wait before the next attempt is taken from the Retry-After header (seconds or a date),
+// without the header it starts at backoff and doubles with every attempt.
+// No wait is longer than maxWait, 0 does not limit it.
+// Other requests (e.g. POSTs) are never repeated.
+func (c *Connection) SetRetry(retries int, backoff, maxWait time.Duration) {
+	c.retries = retries
+	c.retryBackoff = backoff
+	c.retryMaxWait = maxWait
+}
+
+// Send the request, repeating it if the site asks to come back later (see SetRetry)
+func (c Connection) send(req *http.Request) (*http.Response, error) {
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.client.Do(req)
+		if err != nil || req.Method != "GET" || attempt >= c.retries {
+			return resp, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), c.now())
+		if !ok {
+			wait = backoff
+			backoff *= 2
+		}
+		if c.retryMaxWait > 0 && wait > c.retryMaxWait {
+			wait = c.retryMaxWait
+		}
+		debugLog("[Retry]", resp.Status, "waiting", wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Parse the Retry-After header, either delay seconds or an HTTP date.
+// A date in the past means no wait, returns false without a valid header.
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(header, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	if wait := date.Sub(now); wait > 0 {
+		return wait, true
+	}
+
+	return 0, true
+}
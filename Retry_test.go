@@ -0,0 +1,134 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		wait   time.Duration
+		ok     bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 5 ", 5 * time.Second, true},
+		{"0", 0, true},
+		{"Sat, 10 Mar 2018 12:01:30 GMT", 90 * time.Second, true},
+		{"Saturday, 10-Mar-18 12:00:10 GMT", 10 * time.Second, true},
+		{"Sat, 10 Mar 2018 11:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"-3", 0, false},
+		{"soon", 0, false},
+	}
+	for _, test := range tests {
+		wait, ok := parseRetryAfter(test.header, now)
+		if wait != test.wait || ok != test.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", test.header, wait, ok, test.wait, test.ok)
+		}
+	}
+}
+
+// Answer the first requests with the given status codes and Retry-After headers, then with 200
+func retryHandler(statuses []int, retryAfter []string, requests *int, mutex *sync.Mutex) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		n := *requests
+		*requests++
+		if n < len(statuses) {
+			if retryAfter[n] != "" {
+				w.Header().Set("Retry-After", retryAfter[n])
+			}
+			w.WriteHeader(statuses[n])
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+}
+
+func TestRetryAfterCapped(t *testing.T) {
+	var mutex sync.Mutex
+	requests := 0
+	later := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	c := newTestConnection(t, retryHandler([]int{429, 503}, []string{"3600", later}, &requests, &mutex))
+	c.SetRetry(3, time.Hour, 10*time.Millisecond)
+
+	start := time.Now()
+	resp, err := c.Do("GET", "browse.php", nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || requests != 3 {
+		t.Errorf("status = %d after %d requests, want 200 after 3", resp.StatusCode, requests)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("took %v, want the two capped waits of 10ms", elapsed)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var mutex sync.Mutex
+	requests := 0
+	c := newTestConnection(t, retryHandler([]int{503, 503, 503}, []string{"", "", ""}, &requests, &mutex))
+	c.SetRetry(2, 10*time.Millisecond, 0)
+
+	start := time.Now()
+	if _, err := c.Do("GET", "browse.php", nil, nil, ""); err == nil {
+		t.Error("no error after the retries are used up")
+	}
+	if requests != 3 {
+		t.Errorf("%d requests, want 3", requests)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("took %v, want a backoff of 10ms and 20ms", elapsed)
+	}
+}
+
+func TestRetryOnlyGet(t *testing.T) {
+	var mutex sync.Mutex
+	requests := 0
+	c := newTestConnection(t, retryHandler([]int{429, 429}, []string{"0", "0"}, &requests, &mutex))
+
+	// disabled by default
+	resp, err := c.Do("GET", "browse.php", nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 429 || requests != 1 {
+		t.Errorf("status = %d after %d requests, want 429 after 1", resp.StatusCode, requests)
+	}
+
+	c.SetRetry(3, time.Millisecond, time.Second)
+	resp, err = c.Do("POST", "takeshout.php", nil, strings.NewReader("text=hi"), "application/x-www-form-urlencoded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 429 || requests != 2 {
+		t.Errorf("POST: status = %d after %d requests, want 429 without a retry", resp.StatusCode, requests)
+	}
+}
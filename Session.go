@@ -0,0 +1,67 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+type session struct {
+	Url      string
+	Username string
+	Cookies  Cookies
+}
+
+// Serialize the session (session cookies, base url and username), e.g. to store it between runs
+func (c Connection) MarshalSession() ([]byte, error) {
+	return json.Marshal(session{Url: c.url, Username: c.username, Cookies: c.GetCookies()})
+}
+
+// Restore a session serialized by MarshalSession.
+// The session has to belong to the same site and user as the connection (if set).
+// If the site rejects the restored cookies, a new login is done.
+func (c *Connection) RestoreSession(data []byte) error {
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if c.url != "" && s.Url != c.url {
+		return errors.New("session belongs to another site")
+	}
+	if c.username != "" && s.Username != c.username {
+		return errors.New("session belongs to another user")
+	}
+
+	c.url = s.Url
+	c.username = s.Username
+	c.SetCookies(s.Cookies)
+	// the cached feed link contains the passkey of the previous session
+	c.rssLink = ""
+
+	loggedIn, err := c.IsLoggedIn()
+	if err != nil {
+		return err
+	}
+	if !loggedIn {
+		debugLog("[Session] restored session rejected")
+		return c.Login()
+	}
+
+	return nil
+}
@@ -19,6 +19,7 @@ package irrenhaus_api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -29,7 +30,10 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/PuerkitoBio/goquery"
 )
 
 // Unnamed events are still unknown
@@ -65,42 +69,15 @@ type ShoutboxEvent struct {
 }
 
 var shoutboxRegexp map[string]*regexp.Regexp
+var shoutboxRegexpOnce sync.Once
 
 func ShoutboxRead(c *Connection, shoutId int, lastMessageId int64) ([]ShoutboxMessage, error) {
-	c.assureLogin()
-
-	data := url.Values{}
-	data.Add("b", fmt.Sprintf("%d", shoutId))
-	if lastMessageId > 0 {
-		data.Add("lid", fmt.Sprintf("%d", lastMessageId))
-	}
-
-	resp, err := c.get(c.buildUrl("shoutx.php", data))
-	if err != nil {
+	jsonMsg, err := ShoutboxReadRaw(c, shoutId, lastMessageId)
+	if err != nil || jsonMsg == nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
-	// sanitize the json input
-	body, err := sanitizeJSON(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	debugRequest(resp, string(body))
-	if len(body) <= 1 {
-		return nil, nil // no error, just no new data
-	}
-
 	messages := make([]ShoutboxMessage, 0)
-	jsonMsg := make([][]string, 0)
-	err = json.Unmarshal(body, &jsonMsg)
-	if err != nil {
-		if bytes.Contains(body, []byte("Die Serverlast ist Momentan zu hoch")) {
-			return nil, errors.New("serverload")
-		}
-		debugRequest(resp, string(body))
-		return nil, err
-	}
 
 	for i, jmsg := range jsonMsg {
 		// control messages
@@ -132,33 +109,13 @@ func ShoutboxRead(c *Connection, shoutId int, lastMessageId int64) ([]ShoutboxMe
 		if jmsg[0] == "" {
 			continue
 		}
-		id, err := strconv.ParseInt(jmsg[0], 10, 32)
-		if err != nil {
-			debugLog("[ShoutboxRead]", err.Error())
-		}
-		uid, err := strconv.ParseInt(jmsg[1], 10, 32)
-		if err != nil {
-			debugLog("[ShoutboxRead]", err.Error())
-		}
-		date, err := time.Parse("02.01. 15:04", jmsg[2])
-		if err != nil {
-			debugLog("[ShoutboxRead]", err.Error())
-		}
 		messageType := jmsg[6]
 		if messageType != "" {
 			debugLog("unsuppored message type:" + messageType)
 			continue
 		}
-		strMsg := ShoutboxStrip(jmsg[5], c.url)
-		msg := ShoutboxMessage{
-			Id:      id,
-			UserId:  int(uid),
-			User:    jmsg[4],
-			Date:    date,
-			Message: strMsg,
-		}
 
-		messages = append(messages, msg)
+		messages = append(messages, parseShoutboxMessage(jmsg, c.url))
 	}
 
 	// reverse messages
@@ -169,17 +126,238 @@ func ShoutboxRead(c *Connection, shoutId int, lastMessageId int64) ([]ShoutboxMe
 	return messages, nil
 }
 
+// Poll the shoutbox every interval and send the new messages and control events
+// in the order of ShoutboxRead. Both channels are closed when ctx is canceled or a read fails,
+// the error channel then holds the read error or the error of ctx.
+func ShoutboxStream(ctx context.Context, c *Connection, shoutId int, interval time.Duration) (<-chan ShoutboxMessage, <-chan error) {
+	messages := make(chan ShoutboxMessage)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(messages)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		lastMessageId := int64(0)
+		for {
+			read, err := ShoutboxRead(c, shoutId, lastMessageId)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for _, msg := range read {
+				if msg.Event == nil && msg.Id > lastMessageId {
+					lastMessageId = msg.Id
+				}
+				select {
+				case messages <- msg:
+				case <-ctx.Done():
+					errs <- ctx.Err()
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return messages, errs
+}
+
+// Routes the messages and control events of the shoutbox to registered handlers.
+// Handlers which are not registered are skipped.
+type ShoutboxDispatcher struct {
+	onMessage     func(ShoutboxMessage)
+	onDelete      func(ids []int64)
+	onClear       func()
+	onUserMessage func(unread int)
+}
+
+func (d *ShoutboxDispatcher) OnMessage(fn func(ShoutboxMessage)) {
+	d.onMessage = fn
+}
+
+// Called with the ids of deleted messages
+func (d *ShoutboxDispatcher) OnDelete(fn func(ids []int64)) {
+	d.onDelete = fn
+}
+
+// Called if the whole shoutbox was cleared
+func (d *ShoutboxDispatcher) OnClear(fn func()) {
+	d.onClear = fn
+}
+
+// Called with the count of unread private messages
+func (d *ShoutboxDispatcher) OnUserMessage(fn func(unread int)) {
+	d.onUserMessage = fn
+}
+
+// Read the messages and events of the shoutbox stream and dispatch them until ctx is canceled.
+// Returns the read error which ended the stream, or the error of ctx.
+func (d *ShoutboxDispatcher) Run(ctx context.Context, c *Connection, shoutId int, interval time.Duration) error {
+	messages, errs := ShoutboxStream(ctx, c, shoutId, interval)
+	for msg := range messages {
+		d.Dispatch([]ShoutboxMessage{msg})
+	}
+
+	return <-errs
+}
+
+// Route the messages as returned by ShoutboxRead to the handlers
+func (d *ShoutboxDispatcher) Dispatch(messages []ShoutboxMessage) {
+	for _, msg := range messages {
+		if msg.Event == nil {
+			if d.onMessage != nil {
+				d.onMessage(msg)
+			}
+			continue
+		}
+		d.dispatchEvent(msg.Event)
+	}
+}
+
+func (d *ShoutboxDispatcher) dispatchEvent(event *ShoutboxEvent) {
+	if event.Type&ShoutboxEventUserMessage != 0 && d.onUserMessage != nil && len(event.Data) > 1 {
+		unread, err := strconv.ParseInt(strings.TrimSpace(event.Data[1]), 10, 32)
+		if err == nil {
+			d.onUserMessage(int(unread))
+		}
+	}
+	if event.Type&ShoutboxEventDeleteEntry != 0 && len(event.Data) > 3 {
+		action := strings.TrimSpace(event.Data[3])
+		if action == "clear" {
+			if d.onClear != nil {
+				d.onClear()
+			}
+		} else if strings.HasPrefix(action, "del,") && d.onDelete != nil {
+			ids := make([]int64, 0)
+			for _, field := range strings.Split(strings.TrimPrefix(action, "del,"), ",") {
+				id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+				if err == nil {
+					ids = append(ids, id)
+				}
+			}
+			d.onDelete(ids)
+		}
+	}
+}
+
+// Decode a single message row
+func parseShoutboxMessage(jmsg []string, url string) ShoutboxMessage {
+	id, err := strconv.ParseInt(jmsg[0], 10, 64)
+	if err != nil {
+		debugLog("[ShoutboxRead]", err.Error())
+	}
+	uid, err := strconv.ParseInt(jmsg[1], 10, 32)
+	if err != nil {
+		debugLog("[ShoutboxRead]", err.Error())
+	}
+	date, err := time.Parse("02.01. 15:04", jmsg[2])
+	if err != nil {
+		debugLog("[ShoutboxRead]", err.Error())
+	}
+
+	return ShoutboxMessage{
+		Id:      id,
+		UserId:  int(uid),
+		User:    jmsg[4],
+		Date:    date,
+		Message: ShoutboxStrip(jmsg[5], url),
+	}
+}
+
+// Read the shoutbox rows as sent by the site (sanitized json), without decoding them into messages.
+// The first row contains the control event, followed by the messages (newest first).
+// Returns nil if there are no new messages.
+func ShoutboxReadRaw(c *Connection, shoutId int, lastMessageId int64) ([][]string, error) {
+	c.assureLogin()
+
+	data := url.Values{}
+	data.Add("b", fmt.Sprintf("%d", shoutId))
+	if lastMessageId > 0 {
+		data.Add("lid", fmt.Sprintf("%d", lastMessageId))
+	}
+
+	resp, err := c.get(c.buildUrl("shoutx.php", data))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	// sanitize the json input
+	body, err := sanitizeJSON(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	debugRequest(resp, string(body))
+	if len(body) <= 1 {
+		return nil, nil // no error, just no new data
+	}
+
+	jsonMsg, err := unmarshalShoutbox(body)
+	if err != nil {
+		if bytes.Contains(body, []byte("Die Serverlast ist Momentan zu hoch")) {
+			return nil, newRequestError(resp, errors.New("serverload"))
+		}
+		debugRequest(resp, string(body))
+		return nil, err
+	}
+
+	return jsonMsg, nil
+}
+
 // Strip the HTML / format code from the message
 func ShoutboxStrip(msg, url string) (stripped string) {
-	if len(shoutboxRegexp) == 0 {
-		shoutboxRegexpInit()
-	}
+	stripped = stripFormatting(msg, url)
+
+	stripped = strings.Replace(stripped, "<br>\n", "\n", -1)
+	stripped = strings.Replace(stripped, "<br>", "", -1)
+	stripped = strings.Replace(stripped, "<br/>\n", "\n", -1)
+	stripped = strings.Replace(stripped, "<br/>", "", -1)
+	stripped = strings.Replace(stripped, "&nbsp;", " ", -1)
+
+	stripped = html.UnescapeString(stripped)
+
+	return
+}
+
+// Strip the HTML / format code from a longer text like a torrent description.
+// Unlike ShoutboxStrip line breaks and paragraphs are kept as newlines,
+// but never more than one empty line in a row.
+func StripDescription(desc, url string) (stripped string) {
+	stripped = stripFormatting(desc, url)
+
+	stripped = strings.Replace(stripped, "\r", "", -1)
+	stripped = shoutboxRegexp["br"].ReplaceAllString(stripped, "\n")
+	stripped = shoutboxRegexp["paragraph"].ReplaceAllString(stripped, "\n")
+	stripped = strings.Replace(stripped, "&nbsp;", " ", -1)
+
+	stripped = html.UnescapeString(stripped)
+
+	stripped = shoutboxRegexp["emptylines"].ReplaceAllString(stripped, "\n\n")
+	stripped = strings.TrimSpace(stripped)
+
+	return
+}
+
+func stripFormatting(msg, url string) (stripped string) {
+	shoutboxRegexpOnce.Do(shoutboxRegexpInit)
 
 	stripped = shoutboxRegexp["center"].ReplaceAllString(msg, "$1")
 	stripped = shoutboxRegexp["bold"].ReplaceAllString(stripped, "$1")
 	stripped = shoutboxRegexp["italic"].ReplaceAllString(stripped, "$1")
 	stripped = shoutboxRegexp["underline"].ReplaceAllString(stripped, "$1")
-	stripped = shoutboxRegexp["emoji"].ReplaceAllString(stripped, "emoji:$2")
+	emojiOnce.Do(emojiInit)
+	stripped = emojiReplacer.Replace(stripped)
+	// smileys with other attributes and unknown ones
+	stripped = shoutboxRegexp["emoji"].ReplaceAllStringFunc(stripped, emojify)
 	stripped = shoutboxRegexp["img"].ReplaceAllString(stripped, "$1")
 	stripped = shoutboxRegexp["img3"].ReplaceAllString(stripped, "$1")
 	stripped = shoutboxRegexp["color"].ReplaceAllString(stripped, "$2")
@@ -191,62 +369,231 @@ func ShoutboxStrip(msg, url string) (stripped string) {
 	stripped = shoutboxRegexp["pre"].ReplaceAllString(stripped, "$1")
 	stripped = shoutboxRegexp["hxxp"].ReplaceAllString(stripped, "http$1://$2")
 
-	stripped = strings.Replace(stripped, "<br>\n", "\n", -1)
-	stripped = strings.Replace(stripped, "<br>", "", -1)
-	stripped = strings.Replace(stripped, "<br/>\n", "\n", -1)
-	stripped = strings.Replace(stripped, "<br/>", "", -1)
-	stripped = strings.Replace(stripped, "&nbsp;", " ", -1)
+	return
+}
 
-	stripped = emojify(stripped)
+// Check if the current user may write to the shoutbox.
+// The site only shows the input of a shoutbox to users allowed to write to it, so the
+// start page is checked for it; the shoutbox itself is read for errors like a parked account.
+// If the user may not write, reason contains the cause.
+func CanWriteShoutbox(c *Connection, shoutId int) (bool, string, error) {
+	if err := c.assureLogin(); err != nil {
+		return false, "", err
+	}
 
-	stripped = html.UnescapeString(stripped)
+	resp, err := c.get(c.buildUrl("shoutx.php", url.Values{"b": {fmt.Sprintf("%d", shoutId)}}))
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, "", err
+	}
+	debugRequest(resp, string(body))
 
-	return
+	if err := shoutboxAccessError(body); err != nil {
+		return false, err.Error(), nil
+	}
+
+	resp, err = c.get(c.buildUrl("index.php", nil))
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	page, err := readLatin1(resp.Body)
+	if err != nil {
+		return false, "", err
+	}
+	debugRequest(resp, string(page))
+
+	if err := accountStateError(page); err != nil {
+		return false, err.Error(), nil
+	}
+	ok, err := hasShoutboxForm(page, shoutId)
+	if err != nil {
+		return false, "", err
+	}
+	if !ok {
+		return false, "no input for the shoutbox", nil
+	}
+
+	return true, "", nil
+}
+
+// Find the input of the shoutbox on the page. The shoutbox is identified by the
+// b parameter of the form action or a hidden b field, a form without it belongs to shoutbox 1.
+func hasShoutboxForm(page []byte, shoutId int) (bool, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+	bre, _ := regexp.Compile("[?&]b=(\\d+)")
+	doc.Find("form").EachWithBreak(func(i int, form *goquery.Selection) bool {
+		if len(form.Find("[name=shbox_text]").Nodes) == 0 {
+			return true
+		}
+		id := "1"
+		if m := bre.FindStringSubmatch(form.AttrOr("action", "")); m != nil {
+			id = m[1]
+		}
+		if b, ok := form.Find("input[name=b]").Attr("value"); ok {
+			id = b
+		}
+		found = id == fmt.Sprintf("%d", shoutId)
+		return !found
+	})
+
+	return found, nil
+}
+
+// Detect the error pages sent instead of the json data.
+// Only a body which is not json is checked, so messages quoting an error text do not count.
+func shoutboxAccessError(body []byte) error {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] == '[' {
+		return nil
+	}
+	_, err := parseAjaxResult(body)
+	return parkedError(err)
 }
 
 func ShoutboxWrite(c *Connection, shoutId int, message string) (bool, error) {
-	c.assureLogin()
+	msg, err := ShoutboxWriteContext(context.Background(), c, shoutId, message)
+	if err != nil {
+		return false, err
+	}
+
+	return msg != nil, nil
+}
+
+// Write a message to the shoutbox, the POST is aborted if ctx is canceled.
+// Returns the posted message as echoed by the site, or nil if it could not be found in the response.
+// In dry-run mode a message without Id is returned.
+func ShoutboxWriteContext(ctx context.Context, c *Connection, shoutId int, message string) (*ShoutboxMessage, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	if c.shoutboxDedupWindow > 0 {
+		msg, err := recentShoutboxMessage(c, shoutId, message, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if msg != nil {
+			debugLog("[ShoutboxWrite] message already posted:", msg.Id)
+			return msg, nil
+		}
+	}
 
 	data := url.Values{}
 	data.Add("b", fmt.Sprintf("%d", shoutId))
 	datap := url.Values{}
 	datap.Add("shbox_text", message)
 
-	resp, err := c.postForm(c.buildUrl("shoutx.php", data), datap)
+	resp, err := c.postFormContext(ctx, c.buildUrl("shoutx.php", data), datap)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	defer resp.Body.Close()
+	if c.dryRun {
+		return &ShoutboxMessage{
+			UserId:  int(c.GetCookies().Uid),
+			User:    c.username,
+			Date:    time.Now(),
+			Message: message,
+		}, nil
+	}
 	// sanitize the json input
 	body, err := sanitizeJSON(resp.Body)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	debugRequest(resp, string(body))
 
-	jsonMsg := make([][]string, 0)
-	err = json.Unmarshal(body, &jsonMsg)
+	if err := shoutboxAccessError(body); err != nil {
+		return nil, newRequestError(resp, err)
+	}
+
+	jsonMsg, err := unmarshalShoutbox(body)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	for _, jmsg := range jsonMsg {
-		if jmsg[0] == "" {
+	// the messages are sent newest first, so the first match is ours
+	for i, jmsg := range jsonMsg {
+		if i == 0 || len(jmsg) < 7 || jmsg[0] == "" {
 			continue
 		}
 		uid, err := strconv.ParseInt(jmsg[1], 10, 32)
 		if err != nil {
 			debugLog("[ShoutboxWrite]", err.Error())
 		}
-		if uid == c.cookies.Uid {
-			if jmsg[5] == message { // this may fail badly if the original message contained format code
-				return true, nil
-			}
+		if uid != c.GetCookies().Uid {
+			continue
+		}
+		msg := parseShoutboxMessage(jmsg, c.url)
+		if shoutboxMessageMatches(jmsg[5], msg.Message, message) {
+			return &msg, nil
 		}
 	}
 
-	return false, nil
+	return nil, nil
+}
+
+// Enable the duplicate check of ShoutboxWrite(Context): before posting, the shoutbox is read
+// and if the current user posted the same message within the window, that message is returned
+// instead of posting it again. This makes retrying a failed write safe.
+// The site shows the time in minutes only, so the window should be at least a minute.
+// Disabled (0) by default.
+func (c *Connection) SetShoutboxDedupWindow(window time.Duration) {
+	c.shoutboxDedupWindow = window
+}
+
+// Find a message of the current user identical to message, posted within the dedup window
+func recentShoutboxMessage(c *Connection, shoutId int, message string, now time.Time) (*ShoutboxMessage, error) {
+	jsonMsg, err := ShoutboxReadRaw(c, shoutId, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	now = now.In(c.getLocation())
+	for i, jmsg := range jsonMsg {
+		if i == 0 || len(jmsg) < 7 || jmsg[0] == "" || jmsg[6] != "" {
+			continue
+		}
+		msg := parseShoutboxMessage(jmsg, c.url)
+		if int64(msg.UserId) != c.GetCookies().Uid || !shoutboxMessageMatches(jmsg[5], msg.Message, message) {
+			continue
+		}
+		// the date has no year
+		posted := time.Date(now.Year(), msg.Date.Month(), msg.Date.Day(), msg.Date.Hour(), msg.Date.Minute(), 0, 0, now.Location())
+		if posted.After(now.Add(24 * time.Hour)) {
+			posted = posted.AddDate(-1, 0, 0)
+		}
+		// the minute is truncated, so allow one more
+		if now.Sub(posted) <= c.shoutboxDedupWindow+time.Minute {
+			return &msg, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Compare the echoed message with the sent one.
+// The site escapes and formats the message, so the stripped version is compared as well (ignoring whitespace).
+func shoutboxMessageMatches(raw, stripped, sent string) bool {
+	if raw == sent {
+		return true
+	}
+	normalize := func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}
+
+	return normalize(stripped) == normalize(sent)
 }
 
 // Initialize the shoutbox regexp objects
@@ -267,15 +614,18 @@ func shoutboxRegexpInit() {
 	shoutboxRegexp["nfo"], _ = regexp.Compile("<tt><nobr><font face=\"MS Linedraw\" size=\"2\" style=\"font-size: 10pt; line-height: 10pt\">(.+)</font></nobr></tt>")
 	shoutboxRegexp["pre"], _ = regexp.Compile("<tt><nobr>(.+)</nobr></tt>")
 	shoutboxRegexp["hxxp"], _ = regexp.Compile("hxxp(s)?://([^ ]+)")
+	shoutboxRegexp["br"], _ = regexp.Compile("(?i)<br ?/?>\n?")
+	shoutboxRegexp["paragraph"], _ = regexp.Compile("(?i)</?p(?: [^>]*)?>")
+	shoutboxRegexp["emptylines"], _ = regexp.Compile("\n[ \t]*\n(?:[ \t]*\n)+")
 }
 
 var emojis map[string]rune
+var emojiOnce sync.Once
 
-func emojiInit() {
-	if len(emojis) > 0 {
-		return
-	}
+// Replaces the smiley tags in the usual form of the site, which is faster than the emoji regexp
+var emojiReplacer *strings.Replacer
 
+func emojiInit() {
 	emojis = make(map[string]rune)
 
 	emojis["smile1.gif"] = 0x1F600
@@ -516,18 +866,25 @@ func emojiInit() {
 	emojis["rblocked.gif"] = 0xFFFD
 	emojis["maxlocked.gif"] = 0xFFFD
 	emojis["hslocked.gif"] = 0xFFFD
+
+	pairs := make([]string, 0, 2*len(emojis))
+	for image, emoji := range emojis {
+		pairs = append(pairs, "<img src=\"/pic/smilies/"+image+"\" alt=\"\" border=\"0\">", string(emoji))
+	}
+	emojiReplacer = strings.NewReplacer(pairs...)
 }
 
-func emojify(s string) string {
-	emojiInit()
-	var search string
+// Replace a smiley <img> tag with its emoji.
+// Unknown smileys are replaced with "emoji:<image>"
+func emojify(tag string) string {
+	emojiOnce.Do(emojiInit)
 
-	for image, emoji := range emojis {
-		search = "emoji:" + image
-		s = strings.Replace(s, search, string(emoji), -1)
+	image := shoutboxRegexp["emoji"].FindStringSubmatch(tag)[2]
+	if emoji, ok := emojis[image]; ok {
+		return string(emoji)
 	}
 
-	return s
+	return "emoji:" + image
 }
 
 func sanitizeJSON(rd io.Reader) ([]byte, error) {
@@ -537,5 +894,62 @@ func sanitizeJSON(rd io.Reader) ([]byte, error) {
 	}
 	// Replace tabs in the response. Tabs are not allowed in the json standard, but the send it anyway.
 	// Probaby a shitty(custom) json encoder
-	return bytes.Replace(body, []byte("\t"), []byte("    "), -1), nil
+	body = bytes.Replace(body, []byte("\t"), []byte("    "), -1)
+
+	return cleanJSON(body), nil
+}
+
+// Escape control characters in strings and remove trailing commas in arrays and objects,
+// both are sent by the encoder of the site from time to time
+func cleanJSON(body []byte) []byte {
+	cleaned := make([]byte, 0, len(body))
+	inString, escaped := false, false
+	for i := 0; i < len(body); i++ {
+		b := body[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			case b < 0x20:
+				cleaned = append(cleaned, []byte(fmt.Sprintf("\\u%04x", b))...)
+				continue
+			}
+			cleaned = append(cleaned, b)
+			continue
+		}
+
+		switch {
+		case b == '"':
+			inString = true
+		case b == ']' || b == '}':
+			// drop a trailing comma (and the whitespace after it)
+			trimmed := bytes.TrimRight(cleaned, " \r\n")
+			if len(trimmed) > 0 && trimmed[len(trimmed)-1] == ',' {
+				cleaned = trimmed[:len(trimmed)-1]
+			}
+		case b < 0x20 && b != '\n' && b != '\r':
+			continue
+		}
+		cleaned = append(cleaned, b)
+	}
+
+	return cleaned
+}
+
+// Unmarshal the messages of the shoutbox, the error contains the start of the body
+func unmarshalShoutbox(body []byte) ([][]string, error) {
+	jsonMsg := make([][]string, 0)
+	if err := json.Unmarshal(body, &jsonMsg); err != nil {
+		snippet := []rune(string(body))
+		if len(snippet) > 100 {
+			snippet = append(snippet[:100], []rune("...")...)
+		}
+		return nil, fmt.Errorf("invalid shoutbox json: %v (%q)", err, string(snippet))
+	}
+
+	return jsonMsg, nil
 }
@@ -0,0 +1,329 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEmojifyLiteralText(t *testing.T) {
+	// text which looks like a smiley is no image tag and stays as it is
+	messages := []string{
+		"schreib einfach emoji:bf.gif oder /pic/smilies/bf.gif",
+		"emoji:zwinkern.gif:emoji:",
+		`src="/pic/smilies/bf.gif"`,
+	}
+	for _, msg := range messages {
+		if got := ShoutboxStrip(msg, ""); got != msg {
+			t.Errorf("ShoutboxStrip(%q) = %q, want it unchanged", msg, got)
+		}
+	}
+
+	msg := `emoji:bf.gif <img src="/pic/smilies/bf.gif" alt="" border="0">`
+	if got, want := ShoutboxStrip(msg, ""), "emoji:bf.gif \U0001F44C"; got != want {
+		t.Errorf("ShoutboxStrip(%q) = %q, want %q", msg, got, want)
+	}
+}
+
+func TestEmojify(t *testing.T) {
+	messages := map[string]string{
+		`Hallo <img src="/pic/smilies/bf.gif" alt="" border="0">`:        "Hallo \U0001F44C",
+		`<img border="0" src="/pic/smilies/zwinkern.gif" title=";)"> ok`: "\U0001F609 ok",
+		`<img src="/pic/smilies/unbekannt.gif" alt="">`:                  "emoji:unbekannt.gif",
+	}
+	for msg, want := range messages {
+		if got := ShoutboxStrip(msg, ""); got != want {
+			t.Errorf("ShoutboxStrip(%q) = %q, want %q", msg, got, want)
+		}
+	}
+}
+
+// A shoutbox page of 100 messages, every third one with smileys
+func benchmarkMessages() []string {
+	messages := make([]string, 100)
+	for i := range messages {
+		switch i % 3 {
+		case 0:
+			messages[i] = `Danke für den Upload <img src="/pic/smilies/zwinkern.gif" alt="" border="0"> <img src="/pic/smilies/bf.gif" alt="" border="0">`
+		case 1:
+			messages[i] = `<b>Wartung</b> heute Abend ab 22 Uhr, siehe <a href="https://example.org/news">News</a>`
+		default:
+			messages[i] = "Kennt jemand einen guten Seeder für die alten Serien?"
+		}
+	}
+
+	return messages
+}
+
+func BenchmarkShoutboxStrip(b *testing.B) {
+	messages := benchmarkMessages()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, msg := range messages {
+			ShoutboxStrip(msg, "https://example.org")
+		}
+	}
+}
+
+func BenchmarkEmojify(b *testing.B) {
+	tag := `<img src="/pic/smilies/zwinkern.gif" alt="" border="0">`
+	ShoutboxStrip(tag, "")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		emojify(tag)
+	}
+}
+
+// Serve the start page and the json of the shoutbox (or the given error page)
+func shoutboxHandler(t *testing.T, shoutbox []byte) http.Handler {
+	index := readFixture(t, "index.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index.php":
+			w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+			w.Write(index)
+		case "/shoutx.php":
+			w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+			w.Write(shoutbox)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func TestCanWriteShoutbox(t *testing.T) {
+	// a message quotes an error text, which must not be taken for an error page
+	c := newTestConnection(t, shoutboxHandler(t, readFixture(t, "shoutbox.json")))
+
+	ok, reason, err := CanWriteShoutbox(c, 1)
+	if err != nil || !ok {
+		t.Errorf("CanWriteShoutbox(1) = %v, %q, %v, want true", ok, reason, err)
+	}
+	ok, reason, err = CanWriteShoutbox(c, 2)
+	if err != nil || ok || reason == "" {
+		t.Errorf("CanWriteShoutbox(2) = %v, %q, %v, want false with a reason", ok, reason, err)
+	}
+}
+
+func TestCanWriteShoutboxParked(t *testing.T) {
+	c := newTestConnection(t, shoutboxHandler(t, []byte("<span>Fehler</span> Du kannst die Shoutbox nicht benutzen.")))
+
+	ok, reason, err := CanWriteShoutbox(c, 1)
+	if err != nil || ok || reason != ErrAccountParked.Error() {
+		t.Errorf("CanWriteShoutbox = %v, %q, %v, want false, %q", ok, reason, err, ErrAccountParked.Error())
+	}
+}
+
+func TestShoutboxWriteQuotingError(t *testing.T) {
+	c := newTestConnection(t, shoutboxHandler(t, readFixture(t, "shoutbox.json")))
+
+	msg, err := ShoutboxWriteContext(context.Background(), c, 1, "Zugriff verweigert? Bei mir klappt es.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg == nil || msg.Id != 1002 {
+		t.Errorf("unexpected message %+v", msg)
+	}
+}
+
+func TestShoutboxWriteDedup(t *testing.T) {
+	location := berlin(t)
+	// the shoutbox echoes every posted message as written by user 42 just now
+	var mu sync.Mutex
+	messages := []string{`["1001","10","` + time.Now().In(location).Format("02.01. 15:04") + `","","alice","Hallo",""]`}
+	posts := 0
+	index := readFixture(t, "index.html")
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		switch r.URL.Path {
+		case "/index.php":
+			w.Write(index)
+		case "/shoutx.php":
+			if r.Method == http.MethodPost {
+				posts++
+				msg := fmt.Sprintf(`["%d","42","%s","","user",%q,""]`, 1001+posts, time.Now().In(location).Format("02.01. 15:04"), r.PostFormValue("shbox_text"))
+				messages = append([]string{msg}, messages...)
+			}
+			fmt.Fprintf(w, `[["0","0","","","","",""],%s]`, strings.Join(messages, ","))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	c.SetLocation(location)
+	c.SetShoutboxDedupWindow(5 * time.Minute)
+
+	// a retry of the same message finds the first one
+	for i := 0; i < 2; i++ {
+		msg, err := ShoutboxWriteContext(context.Background(), c, 1, "Hallo zusammen")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg == nil || msg.Id != 1002 {
+			t.Errorf("write %d: got %+v, want message 1002", i, msg)
+		}
+	}
+	if posts != 1 {
+		t.Errorf("%d POSTs, want 1", posts)
+	}
+
+	// the same text of another user is not a duplicate
+	if _, err := ShoutboxWrite(c, 1, "Hallo"); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 2 {
+		t.Errorf("%d POSTs, want 2", posts)
+	}
+
+	// outside of the window the message is posted again
+	mu.Lock()
+	messages = []string{`["1002","42","` + time.Now().In(location).Add(-10*time.Minute).Format("02.01. 15:04") + `","","user","Hallo zusammen",""]`}
+	mu.Unlock()
+	if _, err := ShoutboxWrite(c, 1, "Hallo zusammen"); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 3 {
+		t.Errorf("%d POSTs, want 3", posts)
+	}
+}
+
+func TestRecentShoutboxMessageYearRollover(t *testing.T) {
+	location := berlin(t)
+	shoutbox := []byte(`[["0","0","","","","",""],["1002","42","31.12. 23:59","","user","Frohes Neues",""]]`)
+	c := newTestConnection(t, shoutboxHandler(t, shoutbox))
+	c.SetLocation(location)
+	c.SetShoutboxDedupWindow(5 * time.Minute)
+
+	tests := []struct {
+		now    time.Time
+		recent bool
+	}{
+		// the date has no year, so the message belongs to the year before
+		{time.Date(2019, 1, 1, 0, 2, 0, 0, location), true},
+		{time.Date(2018, 12, 31, 23, 59, 30, 0, location), true},
+		{time.Date(2019, 1, 1, 0, 10, 0, 0, location), false},
+		{time.Date(2018, 3, 12, 20, 18, 0, 0, location), false},
+	}
+	for _, tt := range tests {
+		msg, err := recentShoutboxMessage(c, 1, "Frohes Neues", tt.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (msg != nil) != tt.recent {
+			t.Errorf("%v: got %+v, want recent = %v", tt.now, msg, tt.recent)
+		}
+	}
+}
+
+func TestShoutboxDispatcherRun(t *testing.T) {
+	script := []string{
+		`[["0","0","","","","",""],["1002","11","12.03. 20:16","","bob","Hallo",""],["1001","10","12.03. 20:15","","alice","Moin",""]]`,
+		`[["2","0","","","3","",""],["1003","10","12.03. 20:17","","alice","Neu",""]]`,
+		`[["64","0","","","","","del,1001,1002"]]`,
+		``,
+		`[["64","0","","","","","clear"]]`,
+	}
+	var mutex sync.Mutex
+	lids := make([]string, 0)
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.URL.Path != "/shoutx.php" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		if len(lids) < len(script) {
+			w.Write([]byte(script[len(lids)]))
+		}
+		lids = append(lids, r.URL.Query().Get("lid"))
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make([]string, 0)
+	d := &ShoutboxDispatcher{}
+	d.OnMessage(func(msg ShoutboxMessage) {
+		events = append(events, fmt.Sprintf("message %d", msg.Id))
+	})
+	d.OnUserMessage(func(unread int) {
+		events = append(events, fmt.Sprintf("unread %d", unread))
+	})
+	d.OnDelete(func(ids []int64) {
+		events = append(events, fmt.Sprintf("delete %v", ids))
+	})
+	d.OnClear(func() {
+		events = append(events, "clear")
+		cancel()
+	})
+
+	if err := d.Run(ctx, c, 1, time.Millisecond); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v, want context.Canceled", err)
+	}
+	want := []string{"message 1001", "message 1002", "message 1003", "unread 3", "delete [1001 1002]", "clear"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(lids) < 3 || lids[0] != "" || lids[1] != "1002" || lids[2] != "1003" {
+		t.Errorf("lid = %q, want the id of the newest message", lids)
+	}
+}
+
+func TestShoutboxMalformedJSON(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		messages string
+	}{
+		{"shoutbox_tabs.json", `["Spalte    eins    zwei"]`},
+		{"shoutbox_control.json", `["Zeile eins\r\nZeile zwei\a"]`},
+		// the commas in the strings are kept
+		{"shoutbox_trailing.json", `["Hallo, Welt" "[a,b,]"]`},
+	}
+	for _, test := range tests {
+		c := newTestConnection(t, shoutboxHandler(t, readFixture(t, test.fixture)))
+
+		rows, err := ShoutboxReadRaw(c, 1, 0)
+		if err != nil {
+			t.Errorf("%s: %v", test.fixture, err)
+			continue
+		}
+		messages := make([]string, 0)
+		for _, row := range rows[1:] {
+			messages = append(messages, row[5])
+		}
+		if fmt.Sprintf("%q", messages) != test.messages {
+			t.Errorf("%s: got messages %q, want %s", test.fixture, messages, test.messages)
+		}
+	}
+
+	c := newTestConnection(t, shoutboxHandler(t, readFixture(t, "shoutbox_broken.json")))
+	_, err := ShoutboxReadRaw(c, 1, 0)
+	if err == nil || !strings.Contains(err.Error(), "invalid shoutbox json") || !strings.Contains(err.Error(), "abgeschnitten") {
+		t.Errorf("err = %v, want an invalid shoutbox json error with the body", err)
+	}
+}
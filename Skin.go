@@ -0,0 +1,92 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Known layouts of the site
+const (
+	SkinUnknown = "unknown"
+	// the layout the parsers are written for (div.blockinborder, table.tableinborder)
+	SkinDefault = "default"
+	// the old TBDev layout (table.tablea / table.tableb)
+	SkinClassic = "classic"
+)
+
+// The layout of the site as detected by DetectSkin
+type SkinInfo struct {
+	// one of the Skin* constants
+	Name string
+	// the css classes found on the probed page, e.g. "tableinborder"
+	Classes []string
+}
+
+// Check if the parsers support the layout
+func (s SkinInfo) Supported() bool {
+	return s.Name == SkinDefault
+}
+
+// Probe the browse page and classify the layout by its css classes
+func DetectSkin(c *Connection) (SkinInfo, error) {
+	if err := c.assureLogin(); err != nil {
+		return SkinInfo{}, err
+	}
+
+	resp, err := c.get(c.buildUrl("/browse.php", nil))
+	if err != nil {
+		return SkinInfo{}, err
+	}
+	defer resp.Body.Close()
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return SkinInfo{}, err
+	}
+	debugRequest(resp, string(body))
+
+	return parseSkin(bytes.NewReader(body))
+}
+
+func parseSkin(reader io.Reader) (SkinInfo, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return SkinInfo{}, err
+	}
+
+	info := SkinInfo{Name: SkinUnknown, Classes: make([]string, 0)}
+	found := make(map[string]bool)
+	for _, class := range []string{"blockinborder", "centeredtitle", "tableinborder", "tablea", "tableb"} {
+		if len(doc.Find("."+class).Nodes) > 0 {
+			found[class] = true
+			info.Classes = append(info.Classes, class)
+		}
+	}
+
+	switch {
+	case found["blockinborder"] && found["tableinborder"]:
+		info.Name = SkinDefault
+	case found["tablea"] || found["tableb"]:
+		info.Name = SkinClassic
+	}
+
+	return info, nil
+}
@@ -0,0 +1,56 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestDetectSkin(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		name      string
+		classes   string
+		supported bool
+	}{
+		{"skin_default.html", SkinDefault, "[blockinborder centeredtitle tableinborder tablea tableb]", true},
+		{"skin_classic.html", SkinClassic, "[tablea tableb]", false},
+		{"skin_unknown.html", SkinUnknown, "[]", false},
+	}
+	for _, test := range tests {
+		page := readFixture(t, test.fixture)
+		c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/browse.php" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+			w.Write(page)
+		}))
+
+		skin, err := DetectSkin(c)
+		if err != nil {
+			t.Fatalf("%s: %v", test.fixture, err)
+		}
+		if skin.Name != test.name || fmt.Sprint(skin.Classes) != test.classes || skin.Supported() != test.supported {
+			t.Errorf("%s: got %+v (supported %v), want %s %s (supported %v)", test.fixture, skin, skin.Supported(), test.name, test.classes, test.supported)
+		}
+	}
+}
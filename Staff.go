@@ -0,0 +1,51 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"errors"
+)
+
+// Pin a torrent (or remove the pin), staff only.
+// The edit form of the torrent is submitted with all its current values, only the sticky flag is changed.
+// The values are sent ISO-8859-1 encoded like the browser does, so the description is not altered.
+// Returns ErrPermissionDenied if the current user may not change it.
+func SetSticky(c *Connection, torrentId int64, sticky bool) (bool, error) {
+	if err := c.assureLogin(); err != nil {
+		return false, err
+	}
+
+	// only the staff gets the sticky option
+	form, editUrl, err := fetchEditForm(c, torrentId, "sticky")
+	if err != nil {
+		return false, err
+	}
+
+	data := parseFormValues(form)
+	value, ok := yesNoValue(form.Find("[name=sticky]"), sticky)
+	if !ok {
+		return false, errors.New("unknown sticky field")
+	}
+	if value == "" {
+		data.Del("sticky")
+	} else {
+		data.Set("sticky", value)
+	}
+
+	return submitEditForm(c, editUrl, form, data)
+}
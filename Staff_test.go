@@ -0,0 +1,68 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestSetSticky(t *testing.T) {
+	var submitted url.Values
+	c := newTestConnection(t, editHandler(t, readFixture(t, "edit.html"), &submitted))
+
+	ok, err := SetSticky(c, 205, true)
+	if err != nil || !ok {
+		t.Fatalf("SetSticky = %v, %v", ok, err)
+	}
+
+	descr, _ := charmap.ISO8859_1.NewEncoder().String("Grüße aus München & schöne Grüße")
+	if submitted.Get("descr") != descr {
+		t.Errorf("descr = %q, want the unchanged ISO-8859-1 text %q", submitted.Get("descr"), descr)
+	}
+	expected := map[string]string{"id": "205", "name": "Newest.Release.2018", "nfoaction": "keep", "type": "7", "visible": "1", "sticky": "1"}
+	for name, value := range expected {
+		if submitted.Get(name) != value {
+			t.Errorf("%s = %q, want %q", name, submitted.Get(name), value)
+		}
+	}
+
+	if _, err := SetSticky(c, 205, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := submitted["sticky"]; ok {
+		t.Errorf("sticky submitted when removing the pin: %q", submitted["sticky"])
+	}
+}
+
+func TestSetStickyNotStaff(t *testing.T) {
+	page := bytes.Replace(readFixture(t, "edit.html"), []byte(`<input type="checkbox" name="sticky" value="1">`), nil, 1)
+	var submitted url.Values
+	c := newTestConnection(t, editHandler(t, page, &submitted))
+
+	if _, err := SetSticky(c, 205, true); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("err = %v, want ErrPermissionDenied", err)
+	}
+	if submitted != nil {
+		t.Error("the form was submitted")
+	}
+}
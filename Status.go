@@ -0,0 +1,121 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+type SiteState int
+
+const (
+	// the site answers and the session is valid
+	SiteUp SiteState = iota
+	// the site shows the maintenance page
+	SiteMaintenance
+	// the site answers, but shows the login form (no or an expired session)
+	SiteLoginRequired
+	// the site could not be reached or answered with a server error
+	SiteUnreachable
+)
+
+func (s SiteState) String() string {
+	switch s {
+	case SiteUp:
+		return "up"
+	case SiteMaintenance:
+		return "maintenance"
+	case SiteLoginRequired:
+		return "login required"
+	case SiteUnreachable:
+		return "unreachable"
+	}
+
+	return "unknown"
+}
+
+// Probe the landing page and classify the state of the site.
+//
+// The probe does not log in, so it works without valid credentials. The returned
+// error is the cause if the site is unreachable, it is nil for all other states.
+func SiteStatus(c *Connection) (SiteState, error) {
+	req, err := c.newRequest("GET", c.buildUrl("index.php", nil), nil)
+	if err != nil {
+		return SiteUnreachable, err
+	}
+
+	resp, err := c.do(req)
+	if errors.Is(err, ErrSiteMaintenance) {
+		return SiteMaintenance, nil
+	}
+	if err != nil {
+		return SiteUnreachable, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return SiteUnreachable, err
+	}
+	debugRequest(resp, string(body))
+
+	if isLoginRedirect(resp) || bytes.Contains(body, []byte("takelogin.php")) {
+		return SiteLoginRequired, nil
+	}
+
+	return SiteUp, nil
+}
+
+// Check the configuration of the connection, meant as a preflight check on startup.
+//
+// The base url has to be an absolute http(s) url, either the credentials or the session
+// cookies have to be set and the site has to be reachable. All problems are combined
+// in the returned error. The site is probed without logging in.
+func (c *Connection) Validate() error {
+	problems := make([]string, 0)
+
+	if u, err := url.Parse(c.url); err != nil {
+		problems = append(problems, "invalid url: "+err.Error())
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, "invalid url: not an absolute http(s) url")
+	}
+	cookies := c.GetCookies()
+	if (c.username == "" || c.password == "") && (cookies.Uid == 0 || cookies.Pass == "") {
+		problems = append(problems, "neither credentials nor session cookies set")
+	}
+	if c.client == nil {
+		problems = append(problems, "no http client set")
+	}
+
+	// only probe if the url is usable at all
+	if len(problems) == 0 {
+		state, err := SiteStatus(c)
+		if state == SiteUnreachable {
+			problems = append(problems, "site unreachable: "+err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+
+	return nil
+}
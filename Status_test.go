@@ -0,0 +1,102 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSearchMaintenance(t *testing.T) {
+	for _, fixture := range []string{"maintenance.html", "maintenance_bare.html"} {
+		c := newTestConnection(t, pageHandler(readFixture(t, fixture)))
+		if _, err := Search(c, "", nil, false); !errors.Is(err, ErrSiteMaintenance) {
+			t.Errorf("%s: err = %v, want ErrSiteMaintenance", fixture, err)
+		}
+	}
+}
+
+func TestMaintenanceMarkerInContent(t *testing.T) {
+	// a regular page with a torrent mentioning the word
+	page := bytes.Replace(readFixture(t, "browse.html"), []byte("Older.Release.2018"), []byte("Wartungsarbeiten.Doku.2018"), -1)
+	c := newTestConnection(t, pageHandler(page))
+
+	entries, err := Search(c, "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("got %d entries, want 3", len(entries))
+	}
+}
+
+func TestMaintenanceMarkerOverride(t *testing.T) {
+	c := newTestConnection(t, pageHandler([]byte("<html><head><title>Maintenance</title></head><body>Back soon</body></html>")))
+	if _, err := Search(c, "", nil, false); err != nil {
+		t.Errorf("err = %v with the default marker, want none", err)
+	}
+	c.SetMaintenanceMarker("Maintenance")
+	if _, err := Search(c, "", nil, false); !errors.Is(err, ErrSiteMaintenance) {
+		t.Errorf("err = %v with the overridden marker, want ErrSiteMaintenance", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	up := httptest.NewServer(pageHandler(readFixture(t, "index.html")))
+	defer up.Close()
+	down := httptest.NewServer(pageHandler(nil))
+	down.Close()
+
+	tests := []struct {
+		url, username, password string
+		cookies                 Cookies
+		problems                []string
+	}{
+		{up.URL, "user", "secret", Cookies{}, nil},
+		{up.URL, "", "", Cookies{Uid: 42, Pass: "pass"}, nil},
+		{up.URL, "user", "", Cookies{}, []string{"neither credentials nor session cookies set"}},
+		{"irrenhaus.local", "user", "secret", Cookies{}, []string{"not an absolute http(s) url"}},
+		{"ftp://irrenhaus.local", "", "", Cookies{}, []string{"not an absolute http(s) url", "neither credentials nor session cookies set"}},
+		{"http://irrenhaus.local/%zz", "user", "secret", Cookies{}, []string{"invalid url: "}},
+		{down.URL, "user", "secret", Cookies{}, []string{"site unreachable: "}},
+	}
+	for _, test := range tests {
+		c := NewConnection(test.url, test.username, test.password, "")
+		c.SetCookies(test.cookies)
+
+		err := c.Validate()
+		if len(test.problems) == 0 {
+			if err != nil {
+				t.Errorf("%s: Validate = %v, want nil", test.url, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: Validate = nil, want %v", test.url, test.problems)
+			continue
+		}
+		for _, problem := range test.problems {
+			if !strings.Contains(err.Error(), problem) {
+				t.Errorf("%s: Validate = %v, want it to contain %q", test.url, err, problem)
+			}
+		}
+	}
+}
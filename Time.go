@@ -0,0 +1,77 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse a relative time as shown by the site ("vor 3 Stunden", "vor einem Tag", "gerade eben",
+// "heute 12:30", "gestern 23:10") into an absolute time, relative to now and in its location.
+// Days, weeks, months and years are calendar units, so they keep the wall clock time across DST changes.
+// Returns false if the text is no relative time.
+func parseRelativeTime(text string, now time.Time) (time.Time, bool) {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+
+	if strings.Contains(text, "gerade eben") || strings.Contains(text, "jetzt") {
+		return now, true
+	}
+
+	dre, _ := regexp.Compile("(heute|gestern)(?:,)? (?:um )?(\\d{1,2}):(\\d{2})")
+	if m := dre.FindStringSubmatch(text); m != nil {
+		hour, _ := strconv.Atoi(m[2])
+		minute, _ := strconv.Atoi(m[3])
+		day := now
+		if m[1] == "gestern" {
+			day = now.AddDate(0, 0, -1)
+		}
+		return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location()), true
+	}
+
+	re, _ := regexp.Compile("vor (\\d+|einer|einem|ein) (sekunden?|minuten?|stunden?|tage?n?|wochen?|monate?n?|jahre?n?)")
+	m := re.FindStringSubmatch(text)
+	if m == nil {
+		return time.Time{}, false
+	}
+	n := 1
+	if num, err := strconv.Atoi(m[1]); err == nil {
+		n = num
+	}
+
+	switch {
+	case strings.HasPrefix(m[2], "sekunde"):
+		return now.Add(-time.Duration(n) * time.Second), true
+	case strings.HasPrefix(m[2], "minute"):
+		return now.Add(-time.Duration(n) * time.Minute), true
+	case strings.HasPrefix(m[2], "stunde"):
+		return now.Add(-time.Duration(n) * time.Hour), true
+	case strings.HasPrefix(m[2], "tag"):
+		return now.AddDate(0, 0, -n), true
+	case strings.HasPrefix(m[2], "woche"):
+		return now.AddDate(0, 0, -7*n), true
+	case strings.HasPrefix(m[2], "monat"):
+		return now.AddDate(0, -n, 0), true
+	case strings.HasPrefix(m[2], "jahr"):
+		return now.AddDate(-n, 0, 0), true
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,124 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"testing"
+	"time"
+)
+
+// The site runs in Germany, DST started on 25.03.2018 at 02:00 and ended on 28.10.2018 at 03:00
+func berlin(t *testing.T) *time.Location {
+	location, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone data not available:", err)
+	}
+
+	return location
+}
+
+func TestParseRelativeTime(t *testing.T) {
+	location := berlin(t)
+	now := time.Date(2018, 3, 20, 12, 0, 0, 0, location)
+
+	tests := []struct {
+		text string
+		want time.Time
+	}{
+		{"gerade eben", now},
+		{"vor 30 Sekunden", time.Date(2018, 3, 20, 11, 59, 30, 0, location)},
+		{"vor einer Minute", time.Date(2018, 3, 20, 11, 59, 0, 0, location)},
+		{"vor 5 Minuten", time.Date(2018, 3, 20, 11, 55, 0, 0, location)},
+		{"vor 3 Stunden", time.Date(2018, 3, 20, 9, 0, 0, 0, location)},
+		{"vor einem Tag", time.Date(2018, 3, 19, 12, 0, 0, 0, location)},
+		{"vor 2 Tagen", time.Date(2018, 3, 18, 12, 0, 0, 0, location)},
+		{"vor 1 Woche", time.Date(2018, 3, 13, 12, 0, 0, 0, location)},
+		{"vor 2 Monaten", time.Date(2018, 1, 20, 12, 0, 0, 0, location)},
+		{"vor einem Jahr", time.Date(2017, 3, 20, 12, 0, 0, 0, location)},
+		{"heute 08:05", time.Date(2018, 3, 20, 8, 5, 0, 0, location)},
+		{"Gestern, um 23:10", time.Date(2018, 3, 19, 23, 10, 0, 0, location)},
+	}
+	for _, test := range tests {
+		got, ok := parseRelativeTime(test.text, now)
+		if !ok || !got.Equal(test.want) {
+			t.Errorf("%q: got %v, %v, want %v", test.text, got, ok, test.want)
+		}
+	}
+
+	for _, text := range []string{"", "20.03.2018 12:00:00", "vor langer Zeit"} {
+		if got, ok := parseRelativeTime(text, now); ok {
+			t.Errorf("%q: got %v, want no relative time", text, got)
+		}
+	}
+}
+
+func TestParseRelativeTimeDST(t *testing.T) {
+	location := berlin(t)
+
+	tests := []struct {
+		text string
+		now  time.Time
+		want time.Time
+	}{
+		// hours are elapsed time, the clock skips from 02:00 to 03:00
+		{"vor 3 Stunden", time.Date(2018, 3, 25, 4, 0, 0, 0, location), time.Date(2018, 3, 25, 0, 0, 0, 0, location)},
+		// days keep the wall clock time
+		{"vor einem Tag", time.Date(2018, 3, 25, 12, 0, 0, 0, location), time.Date(2018, 3, 24, 12, 0, 0, 0, location)},
+		{"vor einem Tag", time.Date(2018, 10, 28, 12, 0, 0, 0, location), time.Date(2018, 10, 27, 12, 0, 0, 0, location)},
+		{"gestern 23:10", time.Date(2018, 3, 25, 10, 0, 0, 0, location), time.Date(2018, 3, 24, 23, 10, 0, 0, location)},
+		// the clock is set back from 03:00 to 02:00
+		{"vor 2 Stunden", time.Date(2018, 10, 28, 3, 30, 0, 0, location), time.Date(2018, 10, 28, 2, 30, 0, 0, time.FixedZone("CEST", 2*3600))},
+	}
+	for _, test := range tests {
+		got, ok := parseRelativeTime(test.text, test.now)
+		if !ok || !got.Equal(test.want) {
+			t.Errorf("%q at %v: got %v, %v, want %v", test.text, test.now, got, ok, test.want)
+		}
+		if got.Location() != location {
+			t.Errorf("%q: location %v, want %v", test.text, got.Location(), location)
+		}
+	}
+}
+
+func TestParseTorrentListRelative(t *testing.T) {
+	location := berlin(t)
+	now := time.Date(2018, 3, 25, 10, 0, 0, 0, location)
+	c := newTestConnection(t, pageHandler(readFixture(t, "browse_relative.html")))
+	c.SetLocation(location)
+	c.clock = func() time.Time { return now }
+
+	entries, err := NewTorrents(c, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := make(map[int]time.Time)
+	for _, te := range entries {
+		added[te.Id] = te.Added
+	}
+
+	expected := map[int]time.Time{
+		310: time.Date(2018, 3, 25, 7, 0, 0, 0, location),
+		309: time.Date(2018, 3, 24, 23, 10, 0, 0, location),
+		308: time.Date(2018, 3, 24, 10, 0, 0, 0, location),
+	}
+	for id, want := range expected {
+		if got, ok := added[id]; !ok || !got.Equal(want) {
+			t.Errorf("torrent %d: Added = %v, want %v", id, got, want)
+		}
+	}
+}
@@ -20,36 +20,75 @@ package irrenhaus_api
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"html"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
-	"golang.org/x/text/encoding/charmap"
-	"golang.org/x/text/transform"
 
 	"github.com/c2h5oh/datasize"
 	"github.com/fuchsi/irrenhaus-api/Category"
+	"golang.org/x/text/encoding/charmap"
 )
 
 const (
 	pageErrorUploadFailed = "TorrentUpload-Upload fehlgeschlagen!"
+	defaultMaxImages      = 2
 )
 
+// Known (lower case) sentences of the upload error messages.
+// Only whole sentences are matched, the message may quote the torrent name or the NFO.
+var uploadErrorReasons = []struct {
+	needle string
+	reason error
+}{
+	{"dieser torrent existiert bereits", ErrDuplicate},
+	{"ein torrent mit diesem info-hash existiert bereits", ErrDuplicate},
+	{"dieses release wurde bereits hochgeladen", ErrDuplicate},
+	{"du musst eine nfo-datei angeben", ErrMissingNfo},
+	{"es wurde keine nfo-datei angegeben", ErrMissingNfo},
+	{"die nfo-datei fehlt", ErrMissingNfo},
+	{"dieses release ist gesperrt", ErrBannedRelease},
+	{"uploads dieser gruppe sind nicht erlaubt", ErrBannedRelease},
+	{"du musst eine kategorie auswählen", ErrWrongCategory},
+	{"ungültige kategorie", ErrWrongCategory},
+	{"das release passt nicht in diese kategorie", ErrWrongCategory},
+}
+
 type TorrentUpload struct {
 	c *Connection
 
-	Meta        io.Reader
-	Nfo         io.Reader
-	Image1      io.Reader
-	Image2      io.Reader
+	Meta   io.Reader
+	Nfo    io.Reader
+	Images []io.Reader
+	// Deprecated: use Images, only used if Images is empty
+	Image1 io.Reader
+	// Deprecated: use Images, only used if Images is empty
+	Image2 io.Reader
+	// Maximum number of images the site accepts, defaults to 2
+	MaxImages int
+
+	// Filenames of the uploaded files, derived from Name if empty
+	MetaFilename   string
+	NfoFilename    string
+	ImageFilenames []string
+
 	Name        string
 	Description string
 	Category    int
@@ -68,15 +107,45 @@ type TorrentEntry struct {
 	FileCount    int
 	SeederCount  int
 	LeecherCount int
-	SnatchCount  int
-	CommentCount int
-	Uploader     string
+	// seeders + leechers, as shown in the peer summary
+	PeerCount   int
+	SnatchCount int
+	// how often the .torrent was downloaded, equals SnatchCount if the site does not show it
+	DownloadCount int
+	CommentCount  int
+	Uploader      string
+	UploaderId    int
+	Owner         string
+	OwnerId       int
+	LastEdited    time.Time
+	UploaderNote  string
+	Poster        string
+	Images        []string
+	Freeleech     bool
+	// announce url with passkey, only set if the details page shows it
+	Announce string
+	// tags or genres of the release
+	Tags []string
+	// pinned by the staff
+	Sticky bool
+	// only set if requested with DetailsOptions.Nfo
+	Nfo string
+	// end of a temporary freeleech, zero if it is permanent (or the torrent is not freeleech)
+	FreeleechUntil time.Time
+	// only set if requested with DetailsOptions.MediaInfo and the details page has a mediainfo block
+	MediaInfo *MediaInfo
+	// label -> text of all rows of the details table, only set if requested with DetailsOptions.IncludeRaw
+	Raw map[string]string
 
 	Files    []TorrentFile
 	Peers    []Peer
 	Snatches []Snatch
 }
 
+// A file of a torrent.
+// On the details page Size is exact only if the site shows the byte count (as tooltip),
+// otherwise it is computed from the rounded display value (e.g. "1,37 GB").
+// ParseTorrentMeta always returns the exact sizes.
 type TorrentFile struct {
 	Name string
 	Size uint64
@@ -84,6 +153,7 @@ type TorrentFile struct {
 
 type Peer struct {
 	Name        string
+	UserId      int
 	Connectable bool
 	Seeder      bool
 	Uploaded    uint64
@@ -97,16 +167,59 @@ type Peer struct {
 	Client      string
 }
 
+// A snatch of a torrent.
+// Completed is the time the download was finished. Stopped is the time the snatcher
+// stopped seeding, it is not set while Seeding ("Seedet im Moment").
+// LastActive is the last announce of the snatcher, taken from the site's column if shown;
+// otherwise it is the Stopped time for stopped snatches and unknown for active ones.
+// Unknown times are time.Unix(0, 0).
 type Snatch struct {
 	Name       string
+	UserId     int
 	Uploaded   uint64
 	Downloaded uint64
 	Ratio      float64
 	Completed  time.Time
 	Stopped    time.Time
+	LastActive time.Time
 	Seeding    bool
 }
 
+// Size as datasize.ByteSize
+func (te TorrentEntry) SizeBytes() datasize.ByteSize {
+	return datasize.ByteSize(te.Size)
+}
+
+// The amount counted as downloaded when grabbing the torrent now:
+// 0 while the torrent is freeleech (permanently or until FreeleechUntil), the full size otherwise
+func EffectiveDownloadBytes(te *TorrentEntry) uint64 {
+	if te.Freeleech && (te.FreeleechUntil.IsZero() || time.Now().Before(te.FreeleechUntil)) {
+		return 0
+	}
+
+	return te.Size
+}
+
+func (f TorrentFile) SizeBytes() datasize.ByteSize {
+	return datasize.ByteSize(f.Size)
+}
+
+func (p Peer) UploadedBytes() datasize.ByteSize {
+	return datasize.ByteSize(p.Uploaded)
+}
+
+func (p Peer) DownloadedBytes() datasize.ByteSize {
+	return datasize.ByteSize(p.Downloaded)
+}
+
+func (s Snatch) UploadedBytes() datasize.ByteSize {
+	return datasize.ByteSize(s.Uploaded)
+}
+
+func (s Snatch) DownloadedBytes() datasize.ByteSize {
+	return datasize.ByteSize(s.Downloaded)
+}
+
 type TorrentList struct {
 	Page    int64
 	Entries []TorrentEntry
@@ -116,7 +229,132 @@ func DownloadTorrent(c *Connection, id int64) ([]byte, string, error) {
 	if err := c.assureLogin(); err != nil {
 		return nil, "", err
 	}
-	resp, err := c.get(c.buildUrl("/download.php", url.Values{"torrent": {fmt.Sprintf("%d", id)}}))
+
+	return downloadTorrent(context.Background(), c, id)
+}
+
+// Download the .torrent file and verify its info hash (hex, case insensitive).
+// Returns ErrInfoHashMismatch if the hashes differ, an empty expectedInfoHash skips the check.
+func DownloadTorrentVerified(c *Connection, id int64, expectedInfoHash string) ([]byte, string, error) {
+	data, filename, err := DownloadTorrent(c, id)
+	if err != nil || expectedInfoHash == "" {
+		return data, filename, err
+	}
+
+	meta, err := ParseTorrentMeta(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if meta.InfoHash != normalizeInfoHash(expectedInfoHash) {
+		return nil, "", fmt.Errorf("%w: expected %s, got %s", ErrInfoHashMismatch, expectedInfoHash, meta.InfoHash)
+	}
+
+	return data, filename, nil
+}
+
+// Download the .torrent file and parse its meta. The info hash of the meta is checked against
+// the one of the details page, ErrInfoHashMismatch is returned if they differ.
+// The check is skipped if the details page shows no valid info hash.
+func DownloadTorrentMeta(c *Connection, id int64) (*TorrentMeta, []byte, string, error) {
+	te, data, filename, err := FetchTorrent(context.Background(), c, id, DetailsOptions{})
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	meta, err := ParseTorrentMeta(data)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if te.InfoHash != "" && meta.InfoHash != te.InfoHash {
+		return nil, nil, "", fmt.Errorf("%w: details show %s, got %s", ErrInfoHashMismatch, te.InfoHash, meta.InfoHash)
+	}
+
+	return meta, data, filename, nil
+}
+
+// Download a torrent into the watch directory of a bittorrent client and return the path of the file.
+// If announce is not empty, the announce url of the torrent is replaced with it.
+// The file is written to a temporary file first and then renamed, so the client never sees a partial file.
+func GrabToWatchDir(c *Connection, id int64, watchDir string, announce string) (string, error) {
+	data, filename, err := DownloadTorrent(c, id)
+	if err != nil {
+		return "", err
+	}
+	if announce != "" {
+		data, err = RewriteAnnounce(data, announce)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	target := filepath.Join(watchDir, sanitizeTorrentFilename(filename, id))
+	tmp, err := ioutil.TempFile(watchDir, ".grab-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return target, nil
+}
+
+// Normalize an info hash to lower case hex. Base32 hashes (as used in magnet links) are converted.
+// Returns an empty string if the hash is malformed.
+func normalizeInfoHash(hash string) string {
+	hash = strings.Join(strings.Fields(hash), "")
+	hre, _ := regexp.Compile("^[0-9a-fA-F]{40}$")
+	if hre.MatchString(hash) {
+		return strings.ToLower(hash)
+	}
+	bre, _ := regexp.Compile("^[A-Za-z2-7]{32}$")
+	if bre.MatchString(hash) {
+		raw, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		if err == nil {
+			return hex.EncodeToString(raw)
+		}
+	}
+
+	return ""
+}
+
+// Replace the characters which are not allowed in filenames (on any OS) and trim dots and spaces
+func sanitizeFilename(filename string) string {
+	filename = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune("/\\:*?\"<>|", r) {
+			return '_'
+		}
+		return r
+	}, filename)
+
+	return strings.Trim(strings.TrimSpace(filename), ".")
+}
+
+// Make the filename sent by the site safe to use in a directory
+func sanitizeTorrentFilename(filename string, id int64) string {
+	filename = sanitizeFilename(filename)
+	if filename == "" {
+		filename = fmt.Sprintf("%d", id)
+	}
+	if !strings.HasSuffix(strings.ToLower(filename), ".torrent") {
+		filename += ".torrent"
+	}
+
+	return filename
+}
+
+func downloadTorrent(ctx context.Context, c *Connection, id int64) ([]byte, string, error) {
+	resp, err := c.getContext(ctx, c.buildUrl("/download.php", url.Values{"torrent": {fmt.Sprintf("%d", id)}}))
 	if err != nil {
 		return nil, "", err
 	}
@@ -125,7 +363,13 @@ func DownloadTorrent(c *Connection, id int64) ([]byte, string, error) {
 	debugRequest(resp, string(body))
 
 	if resp.StatusCode == 404 {
-		return nil, "", errors.New("torrent not found")
+		return nil, "", newRequestError(resp, ErrTorrentNotFound)
+	}
+	// instead of the torrent an error page is sent if the ratio is below the minimum
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		if msg := ratioGateMessage(body); msg != "" {
+			return nil, "", newRequestError(resp, fmt.Errorf("%w: %s", ErrRatioTooLow, msg))
+		}
 	}
 
 	filename := resp.Header.Get("Content-Disposition")
@@ -141,17 +385,57 @@ func NewUpload(c *Connection, meta io.Reader, nfo io.Reader, image io.Reader, na
 	t := TorrentUpload{
 		Meta:        meta,
 		Nfo:         nfo,
-		Image1:      image,
 		Name:        name,
 		Category:    category,
 		Description: description,
 		c:           c,
 	}
+	if image != nil {
+		t.Images = []io.Reader{image}
+	}
 
 	return t, nil
 }
 
+// Get the filename of an uploaded file, either the override or Name + suffix
+func (t *TorrentUpload) filename(override, suffix string) string {
+	if override != "" {
+		return sanitizeFilename(override)
+	}
+	name := sanitizeFilename(t.Name)
+	if name == "" {
+		name = "upload"
+	}
+
+	return name + suffix
+}
+
+// The images to upload, falls back to Image1 and Image2
+func (t *TorrentUpload) images() []io.Reader {
+	if len(t.Images) > 0 {
+		return t.Images
+	}
+
+	images := make([]io.Reader, 0, 2)
+	for _, image := range []io.Reader{t.Image1, t.Image2} {
+		if image != nil {
+			images = append(images, image)
+		}
+	}
+
+	return images
+}
+
 func (t *TorrentUpload) Upload() error {
+	images := t.images()
+	maxImages := t.MaxImages
+	if maxImages <= 0 {
+		maxImages = defaultMaxImages
+	}
+	if len(images) > maxImages {
+		return fmt.Errorf("too many images: %d, at most %d allowed", len(images), maxImages)
+	}
+
 	if err := t.c.assureLogin(); err != nil {
 		return err
 	}
@@ -163,7 +447,7 @@ func (t *TorrentUpload) Upload() error {
 	bodyWriter.WriteField("type", fmt.Sprintf("%d", t.Category))
 	bodyWriter.WriteField("descr", t.Description)
 
-	metaWriter, err := bodyWriter.CreateFormFile("file", t.Name+".torrent")
+	metaWriter, err := bodyWriter.CreateFormFile("file", t.filename(t.MetaFilename, ".torrent"))
 	if err != nil {
 		debugLog("error writing to buffer")
 		return err
@@ -173,7 +457,7 @@ func (t *TorrentUpload) Upload() error {
 		return err
 	}
 
-	nfoWriter, err := bodyWriter.CreateFormFile("nfo", t.Name+".nfo")
+	nfoWriter, err := bodyWriter.CreateFormFile("nfo", t.filename(t.NfoFilename, ".nfo"))
 	if err != nil {
 		debugLog("error writing to buffer")
 		return err
@@ -183,23 +467,22 @@ func (t *TorrentUpload) Upload() error {
 		return err
 	}
 
-	image1Writer, err := bodyWriter.CreateFormFile("pic1", t.Name+".jpg")
-	if err != nil {
-		debugLog("error writing to buffer")
-		return err
-	}
-	_, err = io.Copy(image1Writer, t.Image1)
-	if err != nil {
-		return err
-	}
-
-	if t.Image2 != nil {
-		image2Writer, err := bodyWriter.CreateFormFile("pic1", t.Name+"_2"+".jpg")
+	for i, image := range images {
+		override := ""
+		if i < len(t.ImageFilenames) {
+			override = t.ImageFilenames[i]
+		}
+		suffix := ".jpg"
+		if i > 0 {
+			suffix = fmt.Sprintf("_%d.jpg", i+1)
+		}
+		filename := t.filename(override, suffix)
+		imageWriter, err := bodyWriter.CreateFormFile(fmt.Sprintf("pic%d", i+1), filename)
 		if err != nil {
 			debugLog("error writing to buffer")
 			return err
 		}
-		_, err = io.Copy(image2Writer, t.Image2)
+		_, err = io.Copy(imageWriter, image)
 		if err != nil {
 			return err
 		}
@@ -209,19 +492,27 @@ func (t *TorrentUpload) Upload() error {
 	bodyWriter.Close()
 
 	resp, err := t.c.post(t.c.buildUrl("takeupload.php", nil), contentType, bodyBuf)
-
+	if err != nil {
+		return err
+	}
 	defer resp.Body.Close()
+	if t.c.dryRun {
+		return nil
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	sbody := string(body)
 	debugRequest(resp, sbody)
 
 	if resp.StatusCode == 404 {
-		return errors.New("upload failed: 404")
+		return newRequestError(resp, errors.New("upload failed"))
 	}
 
 	uploadFailed := false
 
-	doc, err := goquery.NewDocumentFromResponse(resp)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
 	sel := doc.Find(".centeredtitle span")
 	for i := range sel.Nodes {
 		node := sel.Eq(i)
@@ -237,174 +528,687 @@ func (t *TorrentUpload) Upload() error {
 		sel = doc.Find("p+p[style=color:red]")
 		if len(sel.Nodes) > 0 {
 			node := sel.Eq(0)
-			errorMsg = node.Text()
+			errorMsg = strings.TrimSpace(node.Text())
 		}
 
-		if errorMsg == "" {
-			errorMsg = "unknown error"
-		}
-
-		return errors.New("upload failed: " + errorMsg)
+		return parseUploadError(errorMsg)
 	}
 
-	sel = doc.Find("a[href^=details.php]")
+	sel = doc.Find("a[href^='details.php']")
 	if len(sel.Nodes) > 0 {
 		link := sel.Eq(0)
 		href, _ := link.Attr("href")
 		re, _ := regexp.Compile("details\\.php\\?id=(\\d+)")
 		if re.MatchString(href) {
-			t.Id, err = strconv.ParseInt(re.FindStringSubmatch(sbody)[1], 10, 64)
+			t.Id, err = strconv.ParseInt(re.FindStringSubmatch(href)[1], 10, 64)
 			if err != nil {
 				return err
 			}
+			return nil
+		}
+	}
+
+	return &UploadError{Reason: ErrUploadUnknown}
+}
+
+// Map the error message of the upload page to the reason
+func parseUploadError(msg string) error {
+	lower := strings.ToLower(msg)
+	for _, r := range uploadErrorReasons {
+		if strings.Contains(lower, r.needle) {
+			return &UploadError{Reason: r.reason, Message: msg}
 		}
 	}
 
-	return errors.New("upload failed")
+	return &UploadError{Reason: ErrUploadUnknown, Message: msg}
 }
 
+// Search the torrents of the categories (all categories if empty).
+// An empty or blank needle lists all torrents of the categories, like browsing them.
 func Search(c *Connection, needle string, categories []int, dead bool) ([]TorrentEntry, error) {
+	return SearchWithOptions(c, needle, SearchOptions{Categories: categories, Dead: dead})
+}
+
+type SearchOptions struct {
+	Categories []int
+	// include dead torrents
+	Dead bool
+	// only return torrents with at least MinSeeders seeders.
+	// The site has no such filter, so all pages are crawled and filtered client-side.
+	MinSeeders int
+	// order of the results, the newest torrents first by default
+	Sort      SearchSort
+	Ascending bool
+}
+
+type SearchSort int
+
+const (
+	SortAdded SearchSort = iota
+	SortName
+	SortSize
+	SortSeeders
+	SortLeechers
+	SortSnatches
+)
+
+func SearchWithOptions(c *Connection, needle string, opts SearchOptions) ([]TorrentEntry, error) {
+	return SearchContext(context.Background(), c, needle, opts)
+}
+
+// Like SearchWithOptions, the crawl is aborted if ctx is canceled
+func SearchContext(ctx context.Context, c *Connection, needle string, opts SearchOptions) ([]TorrentEntry, error) {
 	if err := c.assureLogin(); err != nil {
 		return nil, err
 	}
-	deadint := 0
-	if dead {
-		deadint = 1
-	}
-	data := url.Values{"search": {needle}, "incldead": {fmt.Sprintf("%d", deadint)}, "orderby": {"added"}}
-	if len(categories) == 1 {
-		data.Add("cat", fmt.Sprintf("%d", categories[0]))
-	} else {
-		for _, cat := range categories {
-			data.Add(fmt.Sprintf("c%d", cat), "1")
-		}
-	}
-	resp, err := c.get(c.buildUrl("/browse.php", data))
+
+	torrents, err := search(ctx, c, searchValues(needle, opts.Categories, opts.Dead))
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	debugRequest(resp, string(body))
-
-	foundTorrents := make(map[int]TorrentEntry)
-	torrentList := make([]TorrentEntry, len(foundTorrents))
-	maxpage := int64(0)
-	chTorrents := make(chan TorrentEntry)
-	chFinished := make(chan bool)
 
-	reader := bytes.NewReader(body)
-	go func(reader io.Reader, chTorrents chan TorrentEntry, chFinished chan bool) {
-		defer func() {
-			// Notify that we're done after this function
-			chFinished <- true
-		}()
-		parseTorrentList(reader, chTorrents)
-	}(reader, chTorrents, chFinished)
+	torrents = filterMinSeeders(torrents, opts.MinSeeders)
+	sortTorrentEntries(torrents, opts.Sort, opts.Ascending)
 
-	doc, err := goquery.NewDocumentFromResponse(resp)
-	if err != nil {
-		return nil, err
-	}
+	return torrents, nil
+}
 
-	re, _ := regexp.Compile("page=(\\d+)")
-	sel := doc.Find("p[align=center] a")
-	for i := range sel.Nodes {
-		node := sel.Eq(i)
-		href, _ := node.Attr("href")
-		matches := re.FindAllStringSubmatch(href, -1)
-		for _, m := range matches {
-			page, _ := strconv.ParseInt(m[1], 10, 32)
-			if page > maxpage {
-				maxpage = page
+// Sort the entries by the given field, equal entries are ordered by id
+// so the order is the same for every crawl of an unchanged list
+func sortTorrentEntries(torrents []TorrentEntry, by SearchSort, ascending bool) {
+	less := func(a, b TorrentEntry) bool {
+		switch by {
+		case SortName:
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+		case SortSize:
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case SortSeeders:
+			if a.SeederCount != b.SeederCount {
+				return a.SeederCount < b.SeederCount
+			}
+		case SortLeechers:
+			if a.LeecherCount != b.LeecherCount {
+				return a.LeecherCount < b.LeecherCount
+			}
+		case SortSnatches:
+			if a.SnatchCount != b.SnatchCount {
+				return a.SnatchCount < b.SnatchCount
+			}
+		default:
+			if !a.Added.Equal(b.Added) {
+				return a.Added.Before(b.Added)
 			}
 		}
+		return a.Id < b.Id
 	}
 
-	if maxpage > 0 {
-		for p := int64(1); p <= maxpage; p++ {
-			data.Set("page", fmt.Sprintf("%d", p))
-			pageURL := c.buildUrl("/browse.php", data)
-			go crawlTorrentList(c, pageURL, p, chTorrents, chFinished)
+	sort.Slice(torrents, func(i, j int) bool {
+		if ascending {
+			return less(torrents[i], torrents[j])
 		}
-	}
+		return less(torrents[j], torrents[i])
+	})
+}
 
-	for p := int64(0); p <= maxpage; {
-		select {
-		case torrent := <-chTorrents:
-			foundTorrents[torrent.Id] = torrent
-			//debugLog("found torrent:", torrent.Id)
-		case <-chFinished:
-			p++
-			//debugLog("finished a parser. now at", p, "of", maxpage)
-		}
-	}
+// Get the first n results of a search (newest first, like the site orders them).
+// Unlike Search the pages are fetched one after another and the crawl stops
+// as soon as n results are collected, which makes it cheap for availability checks.
+func SearchFirst(c *Connection, needle string, categories []int, n int) ([]TorrentEntry, error) {
+	return SearchFirstContext(context.Background(), c, needle, categories, n)
+}
 
-	close(chFinished)
-	close(chTorrents)
+// Like SearchFirst, the crawl stops if ctx is canceled
+func SearchFirstContext(ctx context.Context, c *Connection, needle string, categories []int, n int) ([]TorrentEntry, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
 
-	for _, torrent := range foundTorrents {
-		torrentList = append(torrentList, torrent)
+	list := make([]TorrentEntry, 0, n)
+	if n <= 0 {
+		return list, nil
+	}
+	seen := make(map[int]bool)
+	data := searchValues(needle, categories, false)
+	for page, maxpage := int64(0), int64(0); page <= maxpage; page++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if page > 0 {
+			data.Set("page", fmt.Sprintf("%d", page))
+		}
+		entries, last, err := fetchTorrentListPage(ctx, c, data)
+		if err != nil {
+			return nil, err
+		}
+		maxpage = last
+		for _, entry := range entries {
+			// the list may have shifted while crawling
+			if seen[entry.Id] {
+				continue
+			}
+			seen[entry.Id] = true
+			list = append(list, entry)
+			if len(list) == n {
+				return list, nil
+			}
+		}
 	}
 
-	return torrentList, nil
+	return list, nil
 }
 
-func crawlTorrentList(c *Connection, url string, page int64, chTorrents chan TorrentEntry, chFinished chan bool) {
-	resp, err := c.get(url)
-	//debugLog("Crawl Page:", page)
+// Get the newest torrents of the categories (all categories if empty), newest first.
+// Only the first page of the list is fetched, so at most one page of entries is returned;
+// a limit > 0 reduces it further.
+func NewTorrents(c *Connection, categories []int, limit int) ([]TorrentEntry, error) {
+	return NewTorrentsContext(context.Background(), c, categories, limit)
+}
 
-	defer func() {
-		// Notify that we're done after this function
-		chFinished <- true
-	}()
+func NewTorrentsContext(ctx context.Context, c *Connection, categories []int, limit int) ([]TorrentEntry, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
 
+	entries, _, err := fetchTorrentListPage(ctx, c, searchValues("", categories, false))
 	if err != nil {
-		debugLog("ERROR: Failed to crawl \"" + url + "\"")
-		return
+		return nil, err
+	}
+	sortTorrentEntries(entries, SortAdded, false)
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
 	}
 
-	b := resp.Body
-	defer b.Close() // close Body when the function returns
-
-	parseTorrentList(b, chTorrents)
+	return entries, nil
 }
 
-func parseTorrentList(body io.Reader, ch chan TorrentEntry) {
-	debugLog("Parsing Torrent List")
-
-	doc, err := goquery.NewDocumentFromReader(body)
-	if err != nil {
-		return
+// Get all torrents of the categories (all categories if empty, including dead ones) added between from and to (inclusive), newest first.
+// The pages are fetched one after another until the entries are older than from.
+// The dates of the list are read in the location set with SetLocation.
+func SearchByDateRange(c *Connection, categories []int, from, to time.Time) ([]TorrentEntry, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
 	}
-	doc.Find("table.tableinborder").Each(func(i int, s *goquery.Selection) {
-		firstTd := s.Find("td").First()
-		if firstTd.Text() != "Typ" {
-			return
+
+	list := make([]TorrentEntry, 0)
+	seen := make(map[int]bool)
+	data := searchValues("", categories, true)
+	for page, maxpage := int64(0), int64(0); page <= maxpage; page++ {
+		if page > 0 {
+			data.Set("page", fmt.Sprintf("%d", page))
 		}
-		s.Find("tr").Each(func(i int, s *goquery.Selection) {
-			if i == 0 {
-				return
+		entries, last, err := fetchTorrentListPage(context.Background(), c, data)
+		if err != nil {
+			return nil, err
+		}
+		maxpage = last
+
+		done := false
+		for _, entry := range entries {
+			// the list may have shifted while crawling
+			if seen[entry.Id] {
+				continue
 			}
-			torrentEntry, err := parseTorrentEntry(s)
-			if err != nil {
-				debugLog("ERROR while parsing the torrent entry:", err.Error())
-				return
+			seen[entry.Id] = true
+			if entry.Added.Before(from) {
+				// sticky torrents are listed on top regardless of their age
+				if !entry.Sticky {
+					done = true
+				}
+				continue
 			}
-			//debugLog(torrentEntry)
-			ch <- torrentEntry
-		})
-	})
+			if entry.Added.After(to) {
+				continue
+			}
+			list = append(list, entry)
+		}
+		if done {
+			break
+		}
+	}
+	sortTorrentEntries(list, SortAdded, false)
+
+	return list, nil
 }
 
-func parseTorrentEntry(s *goquery.Selection) (TorrentEntry, error) {
-	te := TorrentEntry{}
-	debugLog("Parsing Torrent Entry")
+// Get the number of torrents per category as shown next to the categories of the browse page
+// (e.g. "1080p (1234)"), keyed by category id. Categories without a count are left out.
+func CategoryCounts(c *Connection) (map[int]int, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.get(c.buildUrl("/browse.php", nil))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	debugRequest(resp, string(body))
+
+	return parseCategoryCounts(bytes.NewReader(body))
+}
+
+func parseCategoryCounts(reader io.Reader) (map[int]int, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int]int)
+	ire, _ := regexp.Compile("cat=(\\d+)")
+	cre, _ := regexp.Compile("\\(([\\d.]+)\\)")
+	doc.Find("a[href*='cat=']").Each(func(i int, link *goquery.Selection) {
+		href, _ := link.Attr("href")
+		m := ire.FindStringSubmatch(href)
+		if m == nil {
+			return
+		}
+		// the count is either part of the link or follows it
+		text := link.Text()
+		if !cre.MatchString(text) && len(link.Parent().Find("a").Nodes) == 1 {
+			text = link.Parent().Text()
+		}
+		cm := cre.FindStringSubmatch(text)
+		if cm == nil {
+			return
+		}
+		id, err := strconv.ParseInt(m[1], 10, 32)
+		if err != nil {
+			return
+		}
+		count, err := strconv.ParseInt(strings.Replace(cm[1], ".", "", -1), 10, 32)
+		if err != nil {
+			return
+		}
+		counts[int(id)] = int(count)
+	})
+
+	return counts, nil
+}
+
+// Return StopWalk from the callback of WalkCategory to end the walk without an error
+var StopWalk = errors.New("stop walk")
+
+// Walk all torrents of a category (including dead ones), newest first.
+// The pages are fetched one after another and fn is called for every torrent,
+// so the list is never held in memory. The walk ends at the first error of fn,
+// which is returned (unless it is StopWalk), or if ctx is canceled.
+func WalkCategory(ctx context.Context, c *Connection, category int, fn func(TorrentEntry) error) error {
+	if err := c.assureLogin(); err != nil {
+		return err
+	}
+
+	seen := make(map[int]bool)
+	data := searchValues("", []int{category}, true)
+	for page, maxpage := int64(0), int64(0); page <= maxpage; page++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if page > 0 {
+			data.Set("page", fmt.Sprintf("%d", page))
+		}
+		entries, last, err := fetchTorrentListPage(ctx, c, data)
+		if err != nil {
+			return err
+		}
+		maxpage = last
+		for _, entry := range entries {
+			// the list may have shifted while walking
+			if seen[entry.Id] {
+				continue
+			}
+			seen[entry.Id] = true
+			if err := fn(entry); err != nil {
+				if err == StopWalk {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func filterMinSeeders(torrents []TorrentEntry, minSeeders int) []TorrentEntry {
+	if minSeeders <= 0 {
+		return torrents
+	}
+
+	filtered := make([]TorrentEntry, 0, len(torrents))
+	for _, torrent := range torrents {
+		if torrent.SeederCount >= minSeeders {
+			filtered = append(filtered, torrent)
+		}
+	}
+
+	return filtered
+}
+
+// Search for torrents linking to the given IMDb id (e.g. tt0133093).
+//
+// browse.php has no dedicated IMDb parameter, so this uses the full text search
+// over name and description (blah=1). Uploads embed the IMDb link in their
+// description, so the id matches there.
+func SearchByIMDb(c *Connection, imdbID string, categories []int) ([]TorrentEntry, error) {
+	re, _ := regexp.Compile("^tt\\d+$")
+	if !re.MatchString(imdbID) {
+		return nil, errors.New("invalid imdb id")
+	}
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	data := searchValues(imdbID, categories, false)
+	data.Set("blah", "1")
+
+	return search(context.Background(), c, data)
+}
+
+// Get a hash (hex sha1) of the first result page of a search.
+// Polling clients can compare it with the previous hash to detect changes without parsing the results.
+func SearchPageHash(c *Connection, needle string, categories []int, dead bool) (string, error) {
+	if err := c.assureLogin(); err != nil {
+		return "", err
+	}
+
+	resp, err := c.get(c.buildUrl("/browse.php", searchValues(needle, categories, dead)))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	debugRequest(resp, string(body))
+
+	hash := sha1.Sum(body)
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+func searchValues(needle string, categories []int, dead bool) url.Values {
+	deadint := 0
+	if dead {
+		deadint = 1
+	}
+	data := url.Values{"incldead": {fmt.Sprintf("%d", deadint)}, "orderby": {"added"}}
+	// without a search term the site lists all torrents
+	if needle = strings.TrimSpace(needle); needle != "" {
+		data.Set("search", needle)
+	}
+	if len(categories) == 1 {
+		data.Add("cat", fmt.Sprintf("%d", categories[0]))
+	} else {
+		for _, cat := range categories {
+			data.Add(fmt.Sprintf("c%d", cat), "1")
+		}
+	}
+
+	return data
+}
+
+func search(ctx context.Context, c *Connection, data url.Values) ([]TorrentEntry, error) {
+	entries, maxpage, err := fetchTorrentListPage(ctx, c, data)
+	if err != nil {
+		return nil, err
+	}
+
+	foundTorrents := make(map[int]TorrentEntry)
+	addTorrent := func(torrent TorrentEntry) {
+		// the same torrent may show up on two pages, if the list changed while crawling
+		if found, ok := foundTorrents[torrent.Id]; !ok || betterTorrentEntry(torrent, found) {
+			foundTorrents[torrent.Id] = torrent
+		}
+	}
+	for _, torrent := range entries {
+		addTorrent(torrent)
+	}
+
+	// the crawlers stop sending as soon as ctx is done, so none of them is left blocked
+	chTorrents := make(chan TorrentEntry)
+	chFinished := make(chan bool)
+	for p := int64(1); p <= maxpage; p++ {
+		data.Set("page", fmt.Sprintf("%d", p))
+		pageURL := c.buildUrl("/browse.php", data)
+		go crawlTorrentList(ctx, c, pageURL, p, chTorrents, chFinished)
+	}
+
+	for p := int64(1); p <= maxpage; {
+		select {
+		case torrent := <-chTorrents:
+			addTorrent(torrent)
+		case <-chFinished:
+			p++
+			//debugLog("finished a parser. now at", p, "of", maxpage)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	torrentList := make([]TorrentEntry, 0, len(foundTorrents))
+	for _, torrent := range foundTorrents {
+		torrentList = append(torrentList, torrent)
+	}
+	// the map has no order, return the newest first like the site does
+	sortTorrentEntries(torrentList, SortAdded, false)
+
+	return torrentList, nil
+}
+
+// Decide which of two entries of the same torrent is kept.
+// Prefers the most seeders, then leechers and snatches, so the result does not depend on the crawl order.
+func betterTorrentEntry(a, b TorrentEntry) bool {
+	if a.SeederCount != b.SeederCount {
+		return a.SeederCount > b.SeederCount
+	}
+	if a.LeecherCount != b.LeecherCount {
+		return a.LeecherCount > b.LeecherCount
+	}
+	if a.SnatchCount != b.SnatchCount {
+		return a.SnatchCount > b.SnatchCount
+	}
+	if a.CommentCount != b.CommentCount {
+		return a.CommentCount > b.CommentCount
+	}
+
+	return a.Name < b.Name
+}
+
+// Get the highest page number of the pagination links of the torrent list
+func parseTorrentListMaxPage(doc *goquery.Document) int64 {
+	maxpage := int64(0)
+	re, _ := regexp.Compile("page=(\\d+)")
+	sel := doc.Find("p[align=center] a")
+	for i := range sel.Nodes {
+		node := sel.Eq(i)
+		href, _ := node.Attr("href")
+		matches := re.FindAllStringSubmatch(href, -1)
+		for _, m := range matches {
+			page, _ := strconv.ParseInt(m[1], 10, 32)
+			if page > maxpage {
+				maxpage = page
+			}
+		}
+	}
+
+	return maxpage
+}
+
+// Fetch and parse a single page of the torrent list, the page is selected by data["page"].
+// Returns the entries in page order and the highest page number.
+func fetchTorrentListPage(ctx context.Context, c *Connection, data url.Values) ([]TorrentEntry, int64, error) {
+	return fetchTorrentList(ctx, c, c.buildUrl("/browse.php", data))
+}
+
+// Get the entries of a page with a torrent list (like browse.php) and the highest page number of its pager
+func fetchTorrentList(ctx context.Context, c *Connection, pageUrl string) ([]TorrentEntry, int64, error) {
+	resp, err := c.getContext(ctx, pageUrl)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	debugRequest(resp, string(body))
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]TorrentEntry, 0)
+	chTorrents := make(chan TorrentEntry)
+	go func() {
+		defer close(chTorrents)
+		parseTorrentList(bytes.NewReader(body), chTorrents, c.getLocation(), c.now())
+	}()
+	for torrent := range chTorrents {
+		entries = append(entries, torrent)
+	}
+
+	return entries, parseTorrentListMaxPage(doc), nil
+}
+
+func crawlTorrentList(ctx context.Context, c *Connection, url string, page int64, chTorrents chan TorrentEntry, chFinished chan bool) {
+	//debugLog("Crawl Page:", page)
+	defer func() {
+		// Notify that we're done after this function, unless nobody waits anymore
+		select {
+		case chFinished <- true:
+		case <-ctx.Done():
+		}
+	}()
+
+	resp, err := c.getContext(ctx, url)
+	if err != nil {
+		debugLog("ERROR: Failed to crawl \"" + url + "\"")
+		return
+	}
+
+	b := resp.Body
+	defer b.Close() // close Body when the function returns
+
+	// parse the whole page first, so sending can be aborted
+	chPage := make(chan TorrentEntry)
+	go func() {
+		defer close(chPage)
+		parseTorrentList(b, chPage, c.getLocation(), c.now())
+	}()
+	entries := make([]TorrentEntry, 0)
+	for torrent := range chPage {
+		entries = append(entries, torrent)
+	}
+
+	for _, torrent := range entries {
+		select {
+		case chTorrents <- torrent:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Relative dates are resolved against now, which has to be in the location of the site
+func parseTorrentList(body io.Reader, ch chan TorrentEntry, location *time.Location, now time.Time) {
+	debugLog("Parsing Torrent List")
+
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return
+	}
+	doc.Find("table.tableinborder").Each(func(i int, s *goquery.Selection) {
+		firstTd := s.Find("td").First()
+		if firstTd.Text() != "Typ" {
+			return
+		}
+		var columns map[string]int
+		s.Find("tr").Each(func(i int, s *goquery.Selection) {
+			if i == 0 {
+				columns = parseTorrentListHeader(s)
+				return
+			}
+			torrentEntry, err := parseTorrentEntry(s, columns, location, now)
+			if err != nil {
+				debugLog("ERROR while parsing the torrent entry:", err.Error())
+				return
+			}
+			//debugLog(torrentEntry)
+			ch <- torrentEntry
+		})
+	})
+}
+
+// Header labels (lower case prefixes) of the torrent list columns
+var torrentListLabels = map[string][]string{
+	"category": {"typ"},
+	"name":     {"name"},
+	"files":    {"dateien"},
+	"comments": {"komm"},
+	"added":    {"hinzugefügt", "datum"},
+	"size":     {"größe"},
+	"snatches": {"fertig", "snatch"},
+	"seeders":  {"seeder"},
+	"leechers": {"leecher"},
+	"uploader": {"uploader", "hochgeladen"},
+}
+
+// Map the fields of the torrent list to their column index by reading the header row.
+// Fields without a matching header keep the index of the default layout.
+func parseTorrentListHeader(s *goquery.Selection) map[string]int {
+	columns := map[string]int{
+		"category": 0,
+		"name":     1,
+		"files":    2,
+		"comments": 3,
+		"added":    4,
+		"size":     6,
+		"snatches": 8,
+		"seeders":  9,
+		"leechers": 10,
+		"uploader": 12,
+	}
+
+	s.Find("td").Each(func(i int, td *goquery.Selection) {
+		label := strings.ToLower(strings.TrimSpace(td.Text()))
+		if label == "" {
+			// some columns only have an icon as header
+			label, _ = td.Find("img").Attr("title")
+			label = strings.ToLower(strings.TrimSpace(label))
+		}
+		if label == "" {
+			return
+		}
+		for field, labels := range torrentListLabels {
+			for _, l := range labels {
+				if strings.HasPrefix(label, l) {
+					columns[field] = i
+				}
+			}
+		}
+	})
+
+	return columns
+}
+
+func parseTorrentEntry(s *goquery.Selection, columns map[string]int, location *time.Location, now time.Time) (TorrentEntry, error) {
+	te := TorrentEntry{}
+	debugLog("Parsing Torrent Entry")
 
 	tds := s.Find("td")
 
 	// Category
-	href, ok := tds.Eq(0).Find("a").First().Attr("href")
+	href, ok := tds.Eq(columns["category"]).Find("a").First().Attr("href")
 	if !ok {
 		return te, errors.New("typ is missing href attr")
 	}
@@ -418,7 +1222,7 @@ func parseTorrentEntry(s *goquery.Selection) (TorrentEntry, error) {
 	}
 
 	// ID
-	link := tds.Eq(1).Find("a").First()
+	link := tds.Eq(columns["name"]).Find("a").First()
 	href, ok = link.Attr("href")
 	if !ok {
 		return te, errors.New("name is missing href attr")
@@ -437,30 +1241,38 @@ func parseTorrentEntry(s *goquery.Selection) (TorrentEntry, error) {
 	}
 	te.Name = name
 
+	// Sticky marker next to the name
+	sticky := tds.Eq(columns["name"]).Find("img[src*=sticky], img[alt*=Sticky], img[title*=Sticky]")
+	te.Sticky = len(sticky.Nodes) > 0
+
 	// Files
 
-	files, err := strconv.ParseInt(tds.Eq(2).Find("a").First().Text(), 10, 32)
+	files, err := parseCount(tds.Eq(columns["files"]))
 	if err != nil {
 		return te, err
 	}
 	te.FileCount = int(files)
 
 	// Comments
-	comments, err := strconv.ParseInt(tds.Eq(3).Find("a").First().Text(), 10, 32)
+	comments, err := parseCount(tds.Eq(columns["comments"]))
 	if err != nil {
 		return te, err
 	}
 	te.CommentCount = int(comments)
 
 	// Added date/time
-	addedTimestamp := tds.Eq(4).Text()
-	te.Added, err = time.Parse("02.01.200615:04:05", addedTimestamp)
+	addedTimestamp := tds.Eq(columns["added"]).Text()
+	te.Added, err = time.ParseInLocation("02.01.200615:04:05", addedTimestamp, location)
 	if err != nil {
-		return te, err
+		added, ok := parseRelativeTime(addedTimestamp, now)
+		if !ok {
+			return te, err
+		}
+		te.Added = added
 	}
 
 	// Size
-	rawSize := tds.Eq(6).Text()
+	rawSize := tds.Eq(columns["size"]).Text()
 	commaIndex := strings.IndexByte(rawSize, ',')
 	// get the part before the ','
 	size, err := strconv.ParseInt(rawSize[0:commaIndex], 10, 32)
@@ -494,28 +1306,28 @@ func parseTorrentEntry(s *goquery.Selection) (TorrentEntry, error) {
 	te.Size = uint64(realsize)
 
 	// Snatch Count
-	snatches, err := strconv.ParseInt(tds.Eq(8).Find("a").First().Text(), 10, 32)
+	snatches, err := parseCount(tds.Eq(columns["snatches"]))
 	if err != nil {
 		return te, err
 	}
 	te.SnatchCount = int(snatches)
 
 	// Seeder Count
-	seeders, err := strconv.ParseInt(tds.Eq(9).Find("a").First().Text(), 10, 32)
+	seeders, err := parseCount(tds.Eq(columns["seeders"]))
 	if err != nil {
 		return te, err
 	}
 	te.SeederCount = int(seeders)
 
 	// Leecher Count
-	leechers, err := strconv.ParseInt(tds.Eq(10).Find("a").First().Text(), 10, 32)
+	leechers, err := parseCount(tds.Eq(columns["leechers"]))
 	if err != nil {
 		return te, err
 	}
 	te.LeecherCount = int(leechers)
 
 	// Uploader
-	link = tds.Eq(12).Find("a")
+	link = tds.Eq(columns["uploader"]).Find("a")
 	if len(link.Nodes) == 1 {
 		te.Uploader = link.Text()
 	} else {
@@ -525,109 +1337,522 @@ func parseTorrentEntry(s *goquery.Selection) (TorrentEntry, error) {
 	return te, nil
 }
 
+// Options for the parts of the details page to load
+type DetailsOptions struct {
+	Files    bool
+	Peers    bool
+	Snatches bool
+	// fetch the NFO too (an additional request)
+	Nfo bool
+	// parse the mediainfo block of video torrents
+	MediaInfo bool
+	// keep all rows of the details table in TorrentEntry.Raw
+	IncludeRaw bool
+
+	SnatchesOptions SnatchesOptions
+}
+
+type SnatchesOptions struct {
+	// Leave out the snatch of the current user
+	ExcludeSelf bool
+}
+
 func Details(c *Connection, id int64, files bool, peers bool, snatches bool) (*TorrentEntry, error) {
 	if err := c.assureLogin(); err != nil {
 		return nil, err
 	}
+
+	return details(context.Background(), c, id, DetailsOptions{Files: files, Peers: peers, Snatches: snatches})
+}
+
+// Fetch the details and the .torrent file of a torrent with a single login check.
+// The returned data and filename are the same as from DownloadTorrent.
+func FetchTorrent(ctx context.Context, c *Connection, id int64, opts DetailsOptions) (te *TorrentEntry, data []byte, filename string, err error) {
+	if err = c.assureLogin(); err != nil {
+		return nil, nil, "", err
+	}
+
+	te, err = details(ctx, c, id, opts)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	data, filename, err = downloadTorrent(ctx, c, id)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return te, data, filename, nil
+}
+
+// Fetch the details of many torrents in parallel, at most SetMaxConcurrency at a time.
+// Failed torrents are reported in the error map and do not abort the others.
+// If ctx is cancelled, the remaining torrents fail with the context error.
+func DetailsMany(ctx context.Context, c *Connection, ids []int64, opts DetailsOptions) (map[int64]*TorrentEntry, map[int64]error) {
+	entries := make(map[int64]*TorrentEntry)
+	errs := make(map[int64]error)
+
+	if err := c.assureLogin(); err != nil {
+		for _, id := range ids {
+			errs[id] = err
+		}
+		return entries, errs
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan bool, c.getMaxConcurrency())
+
+	for _, id := range ids {
+		select {
+		case <-ctx.Done():
+			mutex.Lock()
+			errs[id] = ctx.Err()
+			mutex.Unlock()
+			continue
+		case semaphore <- true:
+		}
+
+		wg.Add(1)
+		go func(id int64) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			te, err := details(ctx, c, id, opts)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				errs[id] = err
+			} else {
+				entries[id] = te
+			}
+		}(id)
+	}
+
+	wg.Wait()
+
+	return entries, errs
+}
+
+// Get the details of a torrent by its info hash (40 hex characters).
+//
+// The site can not search for info hashes, so this compares the info hash of the
+// torrents on the first page of the torrent list (the most recent uploads, including dead ones).
+// The details are fetched SetMaxConcurrency torrents at a time, newest first, until the torrent is found.
+// Older torrents are not found and return ErrTorrentNotFound, unless fetching some of the
+// details failed, then the first of these errors is returned.
+func DetailsByInfoHash(c *Connection, infoHash string) (*TorrentEntry, error) {
+	infoHash = normalizeInfoHash(infoHash)
+	if infoHash == "" {
+		return nil, errors.New("invalid info hash")
+	}
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	entries, _, err := fetchTorrentListPage(ctx, c, searchValues("", nil, true))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, int64(entry.Id))
+	}
+
+	var firstErr error
+	for len(ids) > 0 {
+		n := c.getMaxConcurrency()
+		if n > len(ids) {
+			n = len(ids)
+		}
+		batch := ids[:n]
+		ids = ids[n:]
+
+		found, errs := DetailsMany(ctx, c, batch, DetailsOptions{})
+		for _, id := range batch {
+			if te, ok := found[id]; ok && te.InfoHash == infoHash {
+				return te, nil
+			}
+			if err, ok := errs[id]; ok && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return nil, ErrTorrentNotFound
+}
+
+func details(ctx context.Context, c *Connection, id int64, opts DetailsOptions) (*TorrentEntry, error) {
 	data := url.Values{"id": {fmt.Sprintf("%d", id)}}
-	if files {
+	if opts.Files {
 		data.Set("filelist", "1")
 	}
-	if peers {
+	if opts.Peers {
 		data.Set("dllist", "1")
 	}
-	resp, err := c.get(c.buildUrl("/details.php", data))
+	body, err := fetchDetailsPage(ctx, c, data)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	// encode the response from iso-8859-1, or the umlauts are fucked
-	rd := transform.NewReader(resp.Body, charmap.ISO8859_1.NewDecoder())
-	body, err := ioutil.ReadAll(rd)
-	debugRequest(resp, string(body))
-
-	if resp.StatusCode == 404 {
-		return nil, errors.New("torrent not found")
-	}
 
-	te, err := parseTorrentDetails(bytes.NewReader(body), files, peers)
+	te, err := parseTorrentDetails(bytes.NewReader(body), opts.Files, opts.Peers, c.getLocation(), c.now())
 	if err != nil {
 		return nil, err
 	}
 
-	if snatches {
-		data := url.Values{"id": {fmt.Sprintf("%d", id)}}
-		resp, err := c.get(c.buildUrl("/viewsnatches.php", data))
+	if opts.IncludeRaw {
+		te.Raw, err = parseDetailsRaw(bytes.NewReader(body))
 		if err != nil {
 			return nil, err
 		}
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
-		debugRequest(resp, string(body))
+	}
 
-		if resp.StatusCode == 404 {
-			return te, nil
+	if opts.MediaInfo {
+		te.MediaInfo, err = parseMediaInfo(bytes.NewReader(body), te.Name)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		reader := bytes.NewReader(body)
-		snatches := make([]Snatch, 0)
-		foundSnatches := make(map[string]Snatch)
-		maxpage := int64(0)
-		chSnatch := make(chan Snatch)
-		chFinished := make(chan bool)
+	if opts.Nfo {
+		nfo, err := fetchNfo(ctx, c, id)
+		if err != nil {
+			return nil, err
+		}
+		te.Nfo = nfo
+	}
 
-		go func(reader io.Reader, chSnatch chan Snatch, chFinished chan bool) {
-			defer func() {
-				// Notify that we're done after this function
-				chFinished <- true
-			}()
-			parseSnatches(reader, chSnatch)
-		}(reader, chSnatch, chFinished)
-
-		re, _ := regexp.Compile("<a href=\"(.+&page=(\\d+))\".*>")
-		if re.MatchString(string(body)) {
-			matches := re.FindAllStringSubmatch(string(body), -1)
-			for _, m := range matches {
-				page, _ := strconv.ParseInt(m[2], 10, 32)
-				if page > maxpage {
-					maxpage = page
-				}
-			}
+	if opts.Snatches {
+		snatches, err := fetchSnatches(ctx, c, id, opts.SnatchesOptions)
+		if err != nil {
+			return nil, err
+		}
+		if snatches != nil {
+			te.Snatches = snatches
+		}
+		if len(te.Snatches) == 0 && te.SnatchCount > 0 {
+			debugLog("[Details] no snatches found, but the snatch count is", te.SnatchCount)
+		}
+	}
+
+	return te, nil
+}
+
+// Get the list of users who snatched the torrent
+func Snatches(c *Connection, torrentId int64, opts SnatchesOptions) ([]Snatch, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
 
-			//debugLog("Pages: ", maxpage)
+	return fetchSnatches(context.Background(), c, torrentId, opts)
+}
+
+func fetchSnatches(ctx context.Context, c *Connection, id int64, opts SnatchesOptions) ([]Snatch, error) {
+	data := url.Values{"id": {fmt.Sprintf("%d", id)}}
+	resp, err := c.getContext(ctx, c.buildUrl("/viewsnatches.php", data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return nil, nil
+	}
+
+	reader := bytes.NewReader(body)
+	snatches := make([]Snatch, 0)
+	// keyed by user id, names may not be unique
+	foundSnatches := make(map[string]Snatch)
+	maxpage := int64(0)
+	chSnatch := make(chan Snatch)
+	chFinished := make(chan bool)
+
+	go func(reader io.Reader, chSnatch chan Snatch, chFinished chan bool) {
+		defer func() {
+			// Notify that we're done after this function
+			chFinished <- true
+		}()
+		parseSnatches(reader, chSnatch, c.getLocation())
+	}(reader, chSnatch, chFinished)
 
-			for p := int64(1); p <= maxpage; p++ {
-				data.Set("page", fmt.Sprintf("%d", p))
-				pageUrl := c.buildUrl("/viewsnatches.php", data)
-				go crawlSnatchList(c, pageUrl, p, chSnatch, chFinished)
+	re, _ := regexp.Compile("<a href=\"(.+&page=(\\d+))\".*>")
+	if re.MatchString(string(body)) {
+		matches := re.FindAllStringSubmatch(string(body), -1)
+		for _, m := range matches {
+			page, _ := strconv.ParseInt(m[2], 10, 32)
+			if page > maxpage {
+				maxpage = page
 			}
 		}
 
-		for p := int64(0); p <= maxpage; {
-			select {
-			case snatch := <-chSnatch:
-				foundSnatches[snatch.Name] = snatch
-				//debugLog("found torrent:", torrent.Id)
-			case <-chFinished:
-				p++
-				//debugLog("finished a parser. now at", p, "of", maxpage)
+		//debugLog("Pages: ", maxpage)
+
+		for p := int64(1); p <= maxpage; p++ {
+			data.Set("page", fmt.Sprintf("%d", p))
+			pageUrl := c.buildUrl("/viewsnatches.php", data)
+			go crawlSnatchList(c, pageUrl, p, chSnatch, chFinished)
+		}
+	}
+
+	for p := int64(0); p <= maxpage; {
+		select {
+		case snatch := <-chSnatch:
+			if opts.ExcludeSelf && snatch.UserId != 0 && int64(snatch.UserId) == c.GetCookies().Uid {
+				continue
 			}
+			key := snatch.Name
+			if snatch.UserId != 0 {
+				key = fmt.Sprintf("%d", snatch.UserId)
+			}
+			foundSnatches[key] = snatch
+			//debugLog("found torrent:", torrent.Id)
+		case <-chFinished:
+			p++
+			//debugLog("finished a parser. now at", p, "of", maxpage)
+		}
+	}
+
+	close(chFinished)
+	close(chSnatch)
+
+	for _, snatch := range foundSnatches {
+		snatches = append(snatches, snatch)
+	}
+
+	return snatches, nil
+}
+
+// Get the releases linked as similar torrents on the details page (e.g. the same movie in another quality).
+// Only Id and Name of the entries are set, the torrent itself is left out.
+func SimilarTorrents(c *Connection, torrentId int64) ([]TorrentEntry, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	body, err := fetchDetailsPage(context.Background(), c, url.Values{"id": {fmt.Sprintf("%d", torrentId)}})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSimilarTorrents(bytes.NewReader(body), torrentId)
+}
+
+func fetchDetailsPage(ctx context.Context, c *Connection, data url.Values) ([]byte, error) {
+	resp, err := c.getContext(ctx, c.buildUrl("/details.php", data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// encode the response from iso-8859-1, or the umlauts are fucked
+	body, err := readLatin1(resp.Body)
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return nil, newRequestError(resp, ErrTorrentNotFound)
+	}
+
+	return body, err
+}
+
+// Get the message of the ratio gate page, empty if the page is something else
+func ratioGateMessage(body []byte) string {
+	text, err := readLatin1(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	tre, _ := regexp.Compile("<[^>]+>")
+	for _, line := range strings.Split(tre.ReplaceAllString(string(text), "\n"), "\n") {
+		line = strings.Join(strings.Fields(html.UnescapeString(line)), " ")
+		if strings.Contains(line, "Ratio") && (strings.Contains(line, "zu niedrig") || strings.Contains(line, "mindestens")) {
+			return line
+		}
+	}
+
+	return ""
+}
+
+// Download the NFO file of a torrent with its original (undecoded) bytes.
+// If the site sends the NFO page instead of the file, the file is reconstructed
+// from the page and encoded back to ISO-8859-1.
+func DownloadNfo(c *Connection, torrentId int64) ([]byte, string, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, "", err
+	}
+
+	resp, err := c.get(c.buildUrl("viewnfo.php", url.Values{"id": {fmt.Sprintf("%d", torrentId)}, "download": {"1"}}))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		return nil, "", newRequestError(resp, ErrNotFound)
+	}
+
+	filename := fmt.Sprintf("%d.nfo", torrentId)
+	re, _ := regexp.Compile(`filename="?([^";]+)"?`)
+	if m := re.FindStringSubmatch(resp.Header.Get("Content-Disposition")); m != nil {
+		filename = m[1]
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return body, filename, nil
+	}
+
+	decoded, err := readLatin1(bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	nfo, err := parseNfo(bytes.NewReader(decoded))
+	if err != nil {
+		return nil, "", err
+	}
+	if nfo == "" {
+		return nil, "", newRequestError(resp, ErrNotFound)
+	}
+	data, err := charmap.ISO8859_1.NewEncoder().String(nfo)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return []byte(data), filename, nil
+}
+
+func fetchNfo(ctx context.Context, c *Connection, id int64) (string, error) {
+	resp, err := c.getContext(ctx, c.buildUrl("viewnfo.php", url.Values{"id": {fmt.Sprintf("%d", id)}}))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := readLatin1(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	debugRequest(resp, string(body))
+
+	if resp.StatusCode == 404 {
+		// no nfo
+		return "", nil
+	}
+
+	return parseNfo(bytes.NewReader(body))
+}
+
+// Extract the NFO text from the viewnfo page, the line structure is kept as is
+func parseNfo(reader io.Reader) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return "", err
+	}
+
+	nfo := doc.Find("pre").First()
+	if len(nfo.Nodes) == 0 {
+		nfo = doc.Find("tt").First()
+	}
+	if len(nfo.Nodes) == 0 {
+		return "", nil
+	}
+
+	raw, err := nfo.Html()
+	if err != nil {
+		return "", err
+	}
+	bre, _ := regexp.Compile("(?i)<br ?/?>\n?")
+	tre, _ := regexp.Compile("<[^>]+>")
+	text := bre.ReplaceAllString(strings.Replace(raw, "\r", "", -1), "\n")
+	text = tre.ReplaceAllString(text, "")
+	text = strings.Replace(text, "&nbsp;", " ", -1)
+	text = html.UnescapeString(text)
+
+	return strings.Trim(text, "\n"), nil
+}
+
+// Parse the "Ähnliche Torrents" block of the details page, leaving out the torrent itself
+func parseSimilarTorrents(reader io.Reader, torrentId int64) ([]TorrentEntry, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]TorrentEntry, 0)
+	seen := make(map[int]bool)
+	// not userdetails.php
+	ire, _ := regexp.Compile("(?:^|/)details\\.php\\?id=(\\d+)")
+
+	doc.Find("div.blockinborder").Each(func(i int, s *goquery.Selection) {
+		title := strings.ToLower(s.Find("div.centeredtitle").First().Text())
+		if !strings.Contains(title, "hnliche torrents") {
+			return
 		}
 
-		close(chFinished)
-		close(chSnatch)
+		s.Find("a[href*=details]").Each(func(i int, link *goquery.Selection) {
+			href, _ := link.Attr("href")
+			if !ire.MatchString(href) {
+				return
+			}
+			id, err := strconv.ParseInt(ire.FindStringSubmatch(href)[1], 10, 32)
+			if err != nil || id == torrentId || seen[int(id)] {
+				return
+			}
+			seen[int(id)] = true
+
+			name, ok := link.Attr("title")
+			if !ok {
+				name = link.Text()
+			}
+			list = append(list, TorrentEntry{Id: int(id), Name: strings.TrimSpace(name)})
+		})
+	})
+
+	return list, nil
+}
+
+// Get all label / value rows of the details table, the texts are trimmed.
+// If a label occurs twice, the first row wins.
+func parseDetailsRaw(reader io.Reader) (map[string]string, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
 
-		for _, snatch := range foundSnatches {
-			snatches = append(snatches, snatch)
+	raw := make(map[string]string)
+	doc.Find("div.blockinborder").Each(func(i int, node *goquery.Selection) {
+		if !strings.HasPrefix(node.Find("div.centeredtitle b").Text(), "Details zu") {
+			return
 		}
+		node.Find("div>table.tableinborder").Find("tbody:first-child>tr").Each(func(i int, tr *goquery.Selection) {
+			tds := tr.Children().Filter("td")
+			if len(tds.Nodes) < 2 {
+				return
+			}
+			label := strings.TrimSuffix(strings.TrimSpace(tds.Eq(0).Text()), ":")
+			if label == "" {
+				return
+			}
+			if _, ok := raw[label]; !ok {
+				raw[label] = strings.TrimSpace(tds.Eq(1).Text())
+			}
+		})
+	})
 
-		te.Snatches = snatches
-	}
-
-	return te, nil
+	return raw, nil
 }
 
-func parseTorrentDetails(reader io.Reader, files, peers bool) (*TorrentEntry, error) {
+func parseTorrentDetails(reader io.Reader, files, peers bool, location *time.Location, now time.Time) (*TorrentEntry, error) {
 	doc, err := goquery.NewDocumentFromReader(reader)
 	if err != nil {
 		return nil, err
@@ -671,16 +1896,19 @@ func parseTorrentDetails(reader io.Reader, files, peers bool) (*TorrentEntry, er
 
 	// Info Hash
 	row++
-	te.InfoHash = trs.Eq(row).Find("td").Eq(1).Text()
+	hashTd := findTdByLabel(trs, "Info Hash", "Infohash", "Info-Hash")
+	if hashTd == nil {
+		hashTd = trs.Eq(row).Find("td").Eq(1)
+	}
+	te.InfoHash = normalizeInfoHash(hashTd.Text())
 
 	// Description
 	description := ""
 	row++
 	rawDescription, err := trs.Eq(row).Find("td").Eq(1).After("center").Html()
 	if err == nil {
-		// strip all html tags, i think we can use the shoutbox function for this task
-
-		description = ShoutboxStrip(rawDescription, "")
+		// strip all html tags, keeping the line breaks
+		description = StripDescription(rawDescription, "")
 	}
 	te.Description = description
 
@@ -705,95 +1933,242 @@ func parseTorrentDetails(reader io.Reader, files, peers bool) (*TorrentEntry, er
 
 	// Added
 	row++
-	date, err := time.Parse("2006-01-02 15:04:05", getSecondTd(trs, row).Text())
+	date, err := time.ParseInLocation("2006-01-02 15:04:05", getSecondTd(trs, row).Text(), location)
 	if err != nil {
-		date = time.Unix(0, 0)
+		relative, ok := parseRelativeTime(getSecondTd(trs, row).Text(), now)
+		if ok {
+			date = relative
+		} else {
+			date = time.Unix(0, 0)
+		}
 	}
 	te.Added = date
 
-	// loop until text == 'Fertiggestellt'
+	// Uploader / Owner
+	// the owner row is only shown when the torrent was transferred to another user
+	if td := findTdByLabel(trs, "Hochgeladen von", "Uploader"); td != nil {
+		te.Uploader, te.UploaderId = parseUserLink(td)
+	}
+	if td := findTdByLabel(trs, "Besitzer", "Eigentümer"); td != nil {
+		te.Owner, te.OwnerId = parseUserLink(td)
+	}
+	if te.Owner == "" {
+		te.Owner, te.OwnerId = te.Uploader, te.UploaderId
+	} else if te.Uploader == "" {
+		te.Uploader, te.UploaderId = te.Owner, te.OwnerId
+	}
+
+	// Poster and screenshots
+	// if the layout has no separate cover row, the first picture is used as poster
+	if td := findTdByLabel(trs, "Cover", "Poster"); td != nil {
+		te.Poster, _ = td.Find("img").First().Attr("src")
+	}
+	if td := findTdByLabel(trs, "Bilder", "Screenshots"); td != nil {
+		td.Find("img").Each(func(i int, img *goquery.Selection) {
+			if src, ok := img.Attr("src"); ok && src != te.Poster {
+				te.Images = append(te.Images, src)
+			}
+		})
+	}
+	if te.Poster == "" && len(te.Images) > 0 {
+		te.Poster = te.Images[0]
+		te.Images = te.Images[1:]
+	}
+
+	// Last edit and the note of the uploader, both are optional
+	if td := findTdByLabel(trs, "Zuletzt bearbeitet", "Bearbeitet"); td != nil {
+		// the date may be followed by the name of the editor
+		dre, _ := regexp.Compile("\\d{4}-\\d{2}-\\d{2} \\d{2}:\\d{2}:\\d{2}")
+		edited, err := time.ParseInLocation("2006-01-02 15:04:05", dre.FindString(td.Text()), location)
+		if err == nil {
+			te.LastEdited = edited
+		}
+	}
+	if td := findTdByLabel(trs, "Anmerkung", "Hinweis"); td != nil {
+		rawNote, err := td.Html()
+		if err == nil {
+			te.UploaderNote = StripDescription(rawNote, "")
+		}
+	}
+
+	// Sticky
+	if td := findTdByLabel(trs, "Sticky", "Angepinnt"); td != nil {
+		te.Sticky = strings.HasPrefix(strings.ToLower(strings.TrimSpace(td.Text())), "ja")
+	}
+
+	// Tags / genres, e.g. "Action, Thriller"
+	if td := findTdByLabel(trs, "Tags", "Genre"); td != nil {
+		te.Tags = splitTags(td.Text())
+	}
+
+	// Announce url (contains the passkey, so never log it)
+	if td := findTdByLabel(trs, "Announce", "Tracker"); td != nil {
+		announce, ok := td.Find("a").Attr("href")
+		if !ok {
+			announce = td.Find("input").AttrOr("value", td.Text())
+		}
+		announce = strings.TrimSpace(announce)
+		if strings.HasPrefix(announce, "http://") || strings.HasPrefix(announce, "https://") || strings.HasPrefix(announce, "udp://") {
+			te.Announce = announce
+		}
+	}
+
+	// Freeleech, optionally with a countdown ("Freeleech noch 2 Tage")
+	if td := findTdByLabel(trs, "Freeleech", "OnlyUpload"); td != nil {
+		text := strings.TrimSpace(td.Text())
+		if !strings.HasPrefix(strings.ToLower(text), "nein") {
+			te.Freeleech = true
+			te.FreeleechUntil = parseFreeleechUntil(text, now, location)
+		}
+	}
+
+	// Snatches ('Fertiggestellt'), falls back to the default row
 	prs, _ := regexp.Compile("(\\d+) mal")
 	row += 6
-	if prs.MatchString(getSecondTd(trs, row).Text()) {
-		m := prs.FindStringSubmatch(getSecondTd(trs, row).Text())
+	snatchTd := findTdByLabel(trs, "Fertiggestellt")
+	if snatchTd == nil {
+		snatchTd = getSecondTd(trs, row)
+	}
+	if prs.MatchString(snatchTd.Text()) {
+		m := prs.FindStringSubmatch(snatchTd.Text())
 		temp, err := strconv.ParseInt(m[1], 10, 32)
 		if err != nil {
 			temp = 0
 		}
 		te.SnatchCount = int(temp)
 	}
+	te.DownloadCount = te.SnatchCount
+	if td := findTdByLabel(trs, "Heruntergeladen", "Downloads"); td != nil && prs.MatchString(td.Text()) {
+		temp, err := strconv.ParseInt(prs.FindStringSubmatch(td.Text())[1], 10, 32)
+		if err == nil {
+			te.DownloadCount = int(temp)
+		}
+	}
 
-	// Num Files
-	row += 2
-	temp = strings.Split(getSecondTd(trs, row).Text(), " ")
-	nfiles, err := strconv.ParseInt(strings.Replace(temp[0], ",", "", -1), 10, 32)
-	if err != nil {
-		nfiles = 0
+	// Num Files, the list has its own row if requested
+	if td := findTdByLabel(trs, "Anzahl Dateien", "Dateien"); td != nil {
+		temp = strings.Split(strings.TrimSpace(td.Text()), " ")
+		nfiles, err := strconv.ParseInt(strings.Replace(temp[0], ",", "", -1), 10, 32)
+		if err == nil {
+			te.FileCount = int(nfiles)
+		}
 	}
-	te.FileCount = int(nfiles)
 	if files {
-		row++
-		files, err := parseFileList(getSecondTd(trs, row).Find("table"))
-		if err == nil {
-			te.Files = files
+		if table := findTableByLabel(trs, "Dateiliste", "Dateien"); table != nil {
+			files, err := parseFileList(table)
+			if err == nil {
+				te.Files = files
+				te.FileCount = len(files)
+			}
 		}
-		te.FileCount = len(files)
-		// amount of <tr> elements from file table to row count
-		row += len(files) + 1
 	}
 
-	// Num Peers
+	// Peers, either the lists of seeders and leechers or the summary
 	if peers {
-		row += 2
-		sTable := getSecondTd(trs, row).Find("table")
-		parseSeeders := len(sTable.Nodes) > 0
-		var seeder []Peer
-		if parseSeeders {
-			seeder, _ = parsePeerList(sTable)
+		var seeder, leecher []Peer
+		if table := findTableByLabel(trs, "Seeder"); table != nil {
+			seeder, _ = parsePeerList(table)
 			te.SeederCount = len(seeder)
-			// add amount of <tr> elements from seeders table to row count
-			row += te.SeederCount + 1
 		}
-
-		row++
-		pTable := getSecondTd(trs, row).Find("table")
-		parseLeechers := len(pTable.Nodes) > 0
-
-		var leecher []Peer
-		if parseLeechers {
-			leecher, _ = parsePeerList(pTable)
+		if table := findTableByLabel(trs, "Leecher"); table != nil {
+			leecher, _ = parsePeerList(table)
 			te.LeecherCount = len(leecher)
-			// add amount of <tr> elements from leechers table to row count
-			row += te.LeecherCount + 1
 		}
 
-		if parseSeeders && parseLeechers {
+		if seeder != nil && leecher != nil {
 			te.Peers = append(seeder, leecher...)
-		} else if parseSeeders {
+		} else if seeder != nil {
 			te.Peers = seeder
-		} else if parseLeechers {
+		} else if leecher != nil {
 			te.Peers = leecher
 		}
-	} else {
-		row += 2
+		te.PeerCount = te.SeederCount + te.LeecherCount
+	} else if td := findTdByLabel(trs, "Peers"); td != nil {
+		// "X Seeder, Y Leecher = Z Peer(s) gesamt"
+		parsePeerSummary(td.Text(), &te)
+	}
 
-		prs, _ := regexp.Compile("(\\d+) Seeder, (\\d+) Leecher = (\\d+) Peer\\(s\\) gesamt")
-		if prs.MatchString(getSecondTd(trs, row).Text()) {
-			m := prs.FindStringSubmatch(getSecondTd(trs, row).Text())
-			temp, err := strconv.ParseInt(m[1], 10, 32)
-			if err != nil {
-				temp = 0
-			}
-			te.SeederCount = int(temp)
-			temp, err = strconv.ParseInt(m[2], 10, 32)
-			if err != nil {
-				temp = 0
+	return &te, nil
+}
+
+// Split a list of tags, separated by commas, slashes, semicolons, "|" or " und "
+func splitTags(list string) []string {
+	re, _ := regexp.Compile("\\s*(?:[,;/|]|\\s+und\\s+)\\s*")
+	tags := make([]string, 0)
+	for _, tag := range re.Split(strings.TrimSpace(list), -1) {
+		tag = strings.Join(strings.Fields(tag), " ")
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// Parse the end of a temporary freeleech, either a countdown ("noch 2 Tage 5 Stunden")
+// relative to now or an absolute date ("bis 24.12.2018 20:00") in the given location.
+// Returns the zero time if the text contains neither.
+func parseFreeleechUntil(text string, now time.Time, location *time.Location) time.Time {
+	are, _ := regexp.Compile("bis (\\d{2}\\.\\d{2}\\.\\d{4} \\d{2}:\\d{2})")
+	if m := are.FindStringSubmatch(text); m != nil {
+		until, err := time.ParseInLocation("02.01.2006 15:04", m[1], location)
+		if err == nil {
+			return until
+		}
+	}
+
+	rre, _ := regexp.Compile("(?i)noch(.*)")
+	m := rre.FindStringSubmatch(text)
+	if m == nil {
+		return time.Time{}
+	}
+	units := []struct {
+		pattern string
+		unit    time.Duration
+	}{
+		{"Tage?n?", 24 * time.Hour},
+		{"Std\\.?|Stunden?", time.Hour},
+		{"Min\\.?|Minuten?", time.Minute},
+	}
+	var remaining time.Duration
+	for _, u := range units {
+		ure, _ := regexp.Compile("(?i)(\\d+)\\s*(?:" + u.pattern + ")")
+		if um := ure.FindStringSubmatch(m[1]); um != nil {
+			n, err := strconv.ParseInt(um[1], 10, 32)
+			if err == nil {
+				remaining += time.Duration(n) * u.unit
 			}
-			te.LeecherCount = int(temp)
 		}
 	}
+	if remaining == 0 {
+		return time.Time{}
+	}
 
-	return &te, nil
+	return now.Add(remaining)
+}
+
+// Parse the counts of the peer summary, each count is matched on its own
+// so a changed order or separator does not break the others
+func parsePeerSummary(summary string, te *TorrentEntry) {
+	count := func(pattern string) int {
+		re, _ := regexp.Compile("(?i)(\\d+)\\s*" + pattern)
+		m := re.FindStringSubmatch(summary)
+		if m == nil {
+			return 0
+		}
+		temp, err := strconv.ParseInt(m[1], 10, 32)
+		if err != nil {
+			return 0
+		}
+		return int(temp)
+	}
+
+	te.SeederCount = count("Seeder")
+	te.LeecherCount = count("Leecher")
+	te.PeerCount = count("Peer")
+	if te.PeerCount == 0 {
+		te.PeerCount = te.SeederCount + te.LeecherCount
+	}
 }
 
 func parsePeerList(s *goquery.Selection) ([]Peer, error) {
@@ -812,8 +2187,6 @@ func parsePeerList(s *goquery.Selection) ([]Peer, error) {
 		Client:      "",
 	}
 
-	re, _ := regexp.Compile("(:?(\\d+)d )?([0-9:]+)")
-
 	s.Find("tr").Each(func(i int, s *goquery.Selection) {
 		if i == 0 {
 			return
@@ -825,6 +2198,7 @@ func parsePeerList(s *goquery.Selection) ([]Peer, error) {
 
 		if len(td.Find("a").Nodes) > 0 {
 			peer.Name = td.Find("a").Text()
+			_, peer.UserId = parseUserLink(td)
 		} else {
 			peer.Name = td.Text()
 		}
@@ -881,29 +2255,7 @@ func parsePeerList(s *goquery.Selection) ([]Peer, error) {
 
 		col++
 		td = tds.Eq(col)
-		connected := uint64(0)
-		if re.MatchString(td.Text()) {
-			m := re.FindStringSubmatch(td.Text())
-			if m[1] != "" {
-				temp, err := strconv.ParseUint(m[1], 10, 32)
-				if err != nil {
-					temp = 0
-				}
-				connected += temp * 86400
-			}
-			if m[2] != "" {
-				temp := strings.Split(m[2], ":")
-				multi := uint64(1)
-				for i := len(temp) - 1; i >= 0; i-- {
-					temp2, err := strconv.ParseUint(temp[i], 10, 32)
-					if err != nil {
-						temp2 = 0
-					}
-					connected += temp2 * multi
-					multi *= 60
-				}
-			}
-		}
+		peer.Connected = parseConnected(td.Text())
 
 		col += 2
 		td = tds.Eq(col)
@@ -929,6 +2281,116 @@ func parsePeerList(s *goquery.Selection) ([]Peer, error) {
 	return list, nil
 }
 
+// Get the peer entry of the current user, nil if the user is not a peer of the torrent.
+// The torrent details have to be fetched with peers.
+func MyPeer(c *Connection, te *TorrentEntry) *Peer {
+	for i := range te.Peers {
+		if te.Peers[i].UserId != 0 && int64(te.Peers[i].UserId) == c.GetCookies().Uid {
+			return &te.Peers[i]
+		}
+	}
+
+	return nil
+}
+
+// The relationship of the current user to a torrent
+type TorrentActivity struct {
+	Snatched bool
+	// currently in the peer list as seeder
+	Seeding    bool
+	Thanked    bool
+	Bookmarked bool
+	// transfer of the current session if in the peer list, else of the snatch
+	MyUploaded   uint64
+	MyDownloaded uint64
+}
+
+// Get the activity of the current user on a torrent, combining the peer list,
+// the snatch list, the thanks of the details page and the bookmark list
+func MyTorrentActivity(c *Connection, torrentId int64) (*TorrentActivity, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	body, err := fetchDetailsPage(ctx, c, url.Values{"id": {fmt.Sprintf("%d", torrentId)}, "dllist": {"1"}})
+	if err != nil {
+		return nil, err
+	}
+	te, err := parseTorrentDetails(bytes.NewReader(body), false, true, c.getLocation(), c.now())
+	if err != nil {
+		return nil, err
+	}
+	thanks, err := parseThankList(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	snatches, err := fetchSnatches(ctx, c, torrentId, SnatchesOptions{})
+	if err != nil {
+		return nil, err
+	}
+	bookmarked, err := bookmarks(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	activity := &TorrentActivity{}
+	for _, entry := range bookmarked {
+		if int64(entry.Id) == torrentId {
+			activity.Bookmarked = true
+		}
+	}
+	for _, user := range thanks {
+		if user.UserId != 0 && int64(user.UserId) == c.GetCookies().Uid {
+			activity.Thanked = true
+		}
+	}
+	for _, snatch := range snatches {
+		if snatch.UserId != 0 && int64(snatch.UserId) == c.GetCookies().Uid {
+			activity.Snatched = true
+			activity.MyUploaded = snatch.Uploaded
+			activity.MyDownloaded = snatch.Downloaded
+		}
+	}
+	if peer := MyPeer(c, te); peer != nil {
+		activity.Seeding = peer.Seeder
+		activity.MyUploaded = peer.Uploaded
+		activity.MyDownloaded = peer.Downloaded
+	}
+
+	return activity, nil
+}
+
+// Parse a connection time like "2d 03:04:05" or "04:05" into seconds
+func parseConnected(text string) uint64 {
+	re, _ := regexp.Compile("(:?(\\d+)d )?([0-9:]+)")
+	connected := uint64(0)
+	if re.MatchString(text) {
+		m := re.FindStringSubmatch(text)
+		if m[2] != "" {
+			temp, err := strconv.ParseUint(m[2], 10, 32)
+			if err != nil {
+				temp = 0
+			}
+			connected += temp * 86400
+		}
+		if m[3] != "" {
+			temp := strings.Split(m[3], ":")
+			multi := uint64(1)
+			for i := len(temp) - 1; i >= 0; i-- {
+				temp2, err := strconv.ParseUint(temp[i], 10, 32)
+				if err != nil {
+					temp2 = 0
+				}
+				connected += temp2 * multi
+				multi *= 60
+			}
+		}
+	}
+
+	return connected
+}
+
 func parseFileList(s *goquery.Selection) ([]TorrentFile, error) {
 	list := make([]TorrentFile, 0)
 
@@ -943,6 +2405,9 @@ func parseFileList(s *goquery.Selection) ([]TorrentFile, error) {
 			Name: tds.Eq(0).Text(),
 			Size: stringToDatasize(tds.Eq(1).Text()),
 		}
+		if size, ok := parseExactSize(tds.Eq(1)); ok {
+			file.Size = size
+		}
 
 		list = append(list, file)
 	})
@@ -967,23 +2432,32 @@ func crawlSnatchList(c *Connection, url string, page int64, chSnatch chan Snatch
 	b := resp.Body
 	defer b.Close() // close Body when the function returns
 
-	parseSnatches(b, chSnatch)
+	parseSnatches(b, chSnatch, c.getLocation())
 }
 
-func parseSnatches(reader io.Reader, ch chan Snatch) {
+func parseSnatches(reader io.Reader, ch chan Snatch, location *time.Location) {
 	doc, err := goquery.NewDocumentFromReader(reader)
 	if err != nil {
 		return
 	}
 
-	t := doc.Find("table.tableb")
-	if len(t.Nodes) == 0 {
+	table := findSnatchTable(doc)
+	if table == nil {
+		debugLog("[Snatches] no snatch table found")
 		return
 	}
-	table := t.Eq(0)
 
+	dre, _ := regexp.Compile("\\d{4}-\\d{2}-\\d{2} \\d{2}:\\d{2}:\\d{2}")
+	lastActiveCol := -1
 	table.Find("tr").Each(func(i int, s *goquery.Selection) {
 		if i == 0 {
+			// the column of the last announce is optional
+			s.Find("td, th").Each(func(col int, td *goquery.Selection) {
+				label := strings.ToLower(td.Text())
+				if strings.Contains(label, "letzte") || strings.Contains(label, "zuletzt") {
+					lastActiveCol = col
+				}
+			})
 			return
 		}
 
@@ -994,12 +2468,14 @@ func parseSnatches(reader io.Reader, ch chan Snatch) {
 			Downloaded: 0,
 			Uploaded:   0,
 			Stopped:    time.Unix(0, 0),
+			LastActive: time.Unix(0, 0),
 			Seeding:    false,
 		}
 
 		col := 0
 		td := s.Find("td").Eq(col)
 		snatch.Name = td.Find("a").Text()
+		_, snatch.UserId = parseUserLink(td)
 
 		col++
 		td = s.Find("td").Eq(col)
@@ -1035,7 +2511,7 @@ func parseSnatches(reader io.Reader, ch chan Snatch) {
 		td = s.Find("td").Eq(col)
 		t = td.Find("b").Text()
 
-		date, err := time.Parse("2006-01-02 15:04:05", t)
+		date, err := time.ParseInLocation("2006-01-02 15:04:05", t, location)
 		if err != nil {
 			date = time.Unix(0, 0)
 		}
@@ -1048,21 +2524,60 @@ func parseSnatches(reader io.Reader, ch chan Snatch) {
 		if t == "Seedet im Moment" {
 			snatch.Seeding = true
 		} else {
-			date, err := time.Parse("2006-01-02 15:04:05", t)
+			date, err := time.ParseInLocation("2006-01-02 15:04:05", t, location)
 			if err != nil {
 				date = time.Unix(0, 0)
 			}
 			snatch.Stopped = date
+			snatch.LastActive = date
+		}
+
+		if lastActiveCol > 0 {
+			date, err := time.ParseInLocation("2006-01-02 15:04:05", dre.FindString(s.Find("td").Eq(lastActiveCol).Text()), location)
+			if err == nil {
+				snatch.LastActive = date
+			}
 		}
 
 		ch <- snatch
 	})
 }
 
+// The class of the snatch table depends on the skin, so try the known classes
+// and use the first table whose header has the snatch columns
+func findSnatchTable(doc *goquery.Document) *goquery.Selection {
+	for _, class := range []string{"tableb", "tableinborder", "tablea"} {
+		var found *goquery.Selection
+		doc.Find("table." + class).EachWithBreak(func(i int, table *goquery.Selection) bool {
+			header := strings.ToLower(table.Find("tr").First().Text())
+			if strings.Contains(header, "ratio") && len(table.Find("tr").First().Find("td, th").Nodes) >= 6 {
+				found = table
+				return false
+			}
+			return true
+		})
+		if found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
 func Thank(c *Connection, id int64) (bool, error) {
 	c.assureLogin()
 
-	resp, err := c.get(c.buildUrl("thanksajax.php", url.Values{"torrentid": {fmt.Sprintf("%d", id)}}))
+	thankUrl := c.buildUrl("thanksajax.php", url.Values{"torrentid": {fmt.Sprintf("%d", id)}})
+	if c.dryRun {
+		req, err := c.newRequest("GET", thankUrl, nil)
+		if err != nil {
+			return false, err
+		}
+		dryRunResponse(req)
+		return true, nil
+	}
+
+	resp, err := c.get(thankUrl)
 	if err != nil {
 		return false, err
 	}
@@ -1071,19 +2586,65 @@ func Thank(c *Connection, id int64) (bool, error) {
 	debugRequest(resp, string(body))
 
 	if resp.StatusCode == 404 {
-		return false, errors.New("torrent not found")
+		return false, newRequestError(resp, ErrTorrentNotFound)
 	}
 
-	if strings.Contains(string(body), "<span>Fehler</span>") {
-		return false, errors.New("account parked")
-	}
-	if strings.Contains(string(body), "<span>ERROR</span>") {
-		return false, errors.New("missing torrent id")
+	if ok, err := parseAjaxResult(body); !ok {
+		return false, newRequestError(resp, parkedError(err))
 	}
 
 	return true, nil
 }
 
+// A user as linked on the site
+type UserRef struct {
+	User   string
+	UserId int
+}
+
+// Get the users who thanked for the torrent, as listed on the details page.
+// Returns an empty slice if nobody thanked yet.
+func ThankList(c *Connection, torrentId int64) ([]UserRef, error) {
+	if err := c.assureLogin(); err != nil {
+		return nil, err
+	}
+
+	body, err := fetchDetailsPage(context.Background(), c, url.Values{"id": {fmt.Sprintf("%d", torrentId)}})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseThankList(bytes.NewReader(body))
+}
+
+func parseThankList(reader io.Reader) ([]UserRef, error) {
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]UserRef, 0)
+	td := findTdByLabel(doc.Find("tr"), "Bedankt haben", "Danke", "Thanks")
+	if td == nil {
+		return users, nil
+	}
+
+	re, _ := regexp.Compile("userdetails\\.php\\?id=(\\d+)")
+	td.Find("a[href*=userdetails]").Each(func(i int, link *goquery.Selection) {
+		user := UserRef{User: strings.TrimSpace(link.Text())}
+		href, _ := link.Attr("href")
+		if re.MatchString(href) {
+			id, err := strconv.ParseInt(re.FindStringSubmatch(href)[1], 10, 32)
+			if err == nil {
+				user.UserId = int(id)
+			}
+		}
+		users = append(users, user)
+	})
+
+	return users, nil
+}
+
 func stringToDatasize(str string) uint64 {
 	temp := strings.Split(str, " ")
 	if len(temp) == 1 {
@@ -1116,6 +2677,160 @@ func stringToDatasize(str string) uint64 {
 	return temp3
 }
 
+// Get all values a browser would submit with the form (without the submit buttons)
+func parseFormValues(form *goquery.Selection) url.Values {
+	values := url.Values{}
+	form.Find("input, textarea, select").Each(func(i int, s *goquery.Selection) {
+		name, ok := s.Attr("name")
+		if !ok {
+			return
+		}
+		switch s.Nodes[0].Data {
+		case "textarea":
+			values.Add(name, s.Text())
+		case "select":
+			option := s.Find("option[selected]").First()
+			if len(option.Nodes) == 0 {
+				option = s.Find("option").First()
+			}
+			if len(option.Nodes) > 0 {
+				values.Add(name, option.AttrOr("value", option.Text()))
+			}
+		default:
+			switch strings.ToLower(s.AttrOr("type", "text")) {
+			case "submit", "button", "image", "reset", "file":
+				return
+			case "checkbox", "radio":
+				if _, checked := s.Attr("checked"); !checked {
+					return
+				}
+				values.Add(name, s.AttrOr("value", "on"))
+			default:
+				values.Add(name, s.AttrOr("value", ""))
+			}
+		}
+	})
+
+	return values
+}
+
+// Collect the hidden input fields (tokens, ids) of a form
+func parseHiddenFields(form *goquery.Selection) url.Values {
+	values := url.Values{}
+	form.Find("input[type=hidden]").Each(func(i int, s *goquery.Selection) {
+		name, ok := s.Attr("name")
+		if !ok {
+			return
+		}
+		value, _ := s.Attr("value")
+		values.Add(name, value)
+	})
+
+	return values
+}
+
+// Parse a number from a table cell. The number is usually a link,
+// but e.g. zero seeders are shown as plain text or an empty cell.
+func parseCount(td *goquery.Selection) (int64, error) {
+	text := td.Text()
+	if link := td.Find("a").First(); len(link.Nodes) > 0 {
+		text = link.Text()
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(text, 10, 32)
+}
+
+// Read the exact byte count from the title attribute of a size cell (like "1.234.567 Bytes")
+func parseExactSize(s *goquery.Selection) (uint64, bool) {
+	title, ok := s.Attr("title")
+	if !ok {
+		title, ok = s.Find("[title]").First().Attr("title")
+	}
+	if !ok {
+		return 0, false
+	}
+
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		if r == '.' || r == ',' || r == ' ' {
+			return -1
+		}
+		// not a plain byte count
+		return 'x'
+	}, strings.TrimSuffix(strings.TrimSpace(title), "Bytes"))
+	size, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return size, true
+}
+
 func getSecondTd(s *goquery.Selection, nthTr int) *goquery.Selection {
 	return s.Eq(nthTr).Find("td").Eq(1)
 }
+
+// Find the value cell of the first row whose label cell starts with one of the labels
+func findTdByLabel(s *goquery.Selection, labels ...string) *goquery.Selection {
+	for i := range s.Nodes {
+		tds := s.Eq(i).Find("td")
+		if len(tds.Nodes) < 2 {
+			continue
+		}
+		label := strings.TrimSpace(tds.Eq(0).Text())
+		for _, l := range labels {
+			if strings.HasPrefix(label, l) {
+				return tds.Eq(1)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Find the first table in the value cell of a row whose label starts with one of the labels.
+// Rows without a table are skipped, like the rows of the nested lists.
+func findTableByLabel(s *goquery.Selection, labels ...string) *goquery.Selection {
+	for i := range s.Nodes {
+		tds := s.Eq(i).Find("td")
+		if len(tds.Nodes) < 2 {
+			continue
+		}
+		label := strings.TrimSpace(tds.Eq(0).Text())
+		for _, l := range labels {
+			if strings.HasPrefix(label, l) {
+				if table := tds.Eq(1).Find("table").First(); len(table.Nodes) > 0 {
+					return table
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Extract the user name and id from a cell containing a userdetails.php link
+func parseUserLink(s *goquery.Selection) (string, int) {
+	link := s.Find("a[href*=userdetails]").First()
+	if len(link.Nodes) == 0 {
+		return strings.TrimSpace(s.Text()), 0
+	}
+
+	name := strings.TrimSpace(link.Text())
+	href, _ := link.Attr("href")
+	re, _ := regexp.Compile("userdetails\\.php\\?id=(\\d+)")
+	if re.MatchString(href) {
+		id, err := strconv.ParseInt(re.FindStringSubmatch(href)[1], 10, 32)
+		if err == nil {
+			return name, int(id)
+		}
+	}
+
+	return name, 0
+}
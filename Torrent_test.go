@@ -0,0 +1,1387 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/c2h5oh/datasize"
+)
+
+func TestDetailsOwner(t *testing.T) {
+	tests := []struct {
+		fixture string
+		owner   string
+		ownerId int
+	}{
+		{"details_owner.html", "bob", 11},
+		// without an owner row the uploader owns the torrent
+		{"details.html", "alice", 10},
+	}
+	for _, test := range tests {
+		c := newTestConnection(t, pageHandler(readFixture(t, test.fixture)))
+		te, err := Details(c, 205, false, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if te.Uploader != "alice" || te.UploaderId != 10 {
+			t.Errorf("%s: uploader = %q (%d), want alice (10)", test.fixture, te.Uploader, te.UploaderId)
+		}
+		if te.Owner != test.owner || te.OwnerId != test.ownerId {
+			t.Errorf("%s: owner = %q (%d), want %s (%d)", test.fixture, te.Owner, te.OwnerId, test.owner, test.ownerId)
+		}
+	}
+}
+
+func TestParseTorrentListColumnOrder(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(readFixture(t, "browse_columns.html")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := parseTorrentListHeader(doc.Find("table.tableinborder tr").First())
+	want := map[string]int{"category": 0, "name": 1, "added": 2, "size": 3, "seeders": 4, "leechers": 5, "snatches": 6, "files": 7, "comments": 8, "uploader": 9}
+	if !reflect.DeepEqual(columns, want) {
+		t.Errorf("columns = %v, want %v", columns, want)
+	}
+
+	found := make(map[int]TorrentEntry)
+	for _, te := range parseTorrentListFixture(t, "browse_columns.html") {
+		found[te.Id] = te
+	}
+	te, ok := found[506]
+	if !ok || len(found) != 2 {
+		t.Fatalf("got %d torrents, want 507 and 506", len(found))
+	}
+	if te.Name != "Epsilon.2018" || te.Category != 18 || te.Size != 5*1024*1024*1024 {
+		t.Errorf("got %q in %d with %d bytes, want Epsilon.2018 in 18 with 5 GB", te.Name, te.Category, te.Size)
+	}
+	if te.FileCount != 1 || te.CommentCount != 0 || te.SnatchCount != 40 || te.SeederCount != 20 || te.LeecherCount != 4 {
+		t.Errorf("got %d files, %d comments, %d snatches, %d seeders and %d leechers, want 1, 0, 40, 20 and 4",
+			te.FileCount, te.CommentCount, te.SnatchCount, te.SeederCount, te.LeecherCount)
+	}
+	if want := time.Date(2018, 3, 9, 8, 0, 0, 0, time.UTC); !te.Added.Equal(want) {
+		t.Errorf("Added = %v, want %v", te.Added, want)
+	}
+}
+
+// Parse a torrent list page of the testdata directory
+func parseTorrentListFixture(t *testing.T, name string) []TorrentEntry {
+	body := readFixture(t, name)
+	ch := make(chan TorrentEntry)
+	go func() {
+		defer close(ch)
+		parseTorrentList(bytes.NewReader(body), ch, time.UTC, time.Now())
+	}()
+	entries := make([]TorrentEntry, 0)
+	for te := range ch {
+		entries = append(entries, te)
+	}
+
+	return entries
+}
+
+func TestSimilarTorrents(t *testing.T) {
+	c := newTestConnection(t, pageHandler(readFixture(t, "details_similar.html")))
+
+	// 205 itself is left out, the file list link of 198 does not add it twice
+	entries, err := SimilarTorrents(c, 205)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TorrentEntry{
+		{Id: 198, Name: "Newest.Release.2018.German.1080p.BluRay.x264"},
+		{Id: 171, Name: "Newest.Release.2018.German.720p.WEB"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries %+v, want %d", len(entries), entries, len(want))
+	}
+	for i, entry := range entries {
+		if entry.Id != want[i].Id || entry.Name != want[i].Name {
+			t.Errorf("entry %d: got %d %q, want %d %q", i, entry.Id, entry.Name, want[i].Id, want[i].Name)
+		}
+	}
+
+	c = newTestConnection(t, pageHandler(readFixture(t, "details.html")))
+	entries, err = SimilarTorrents(c, 205)
+	if err != nil || entries == nil || len(entries) != 0 {
+		t.Errorf("SimilarTorrents = %v, %v without the block, want an empty slice", entries, err)
+	}
+}
+
+func TestDetailsDescriptionParagraphs(t *testing.T) {
+	c := newTestConnection(t, pageHandler(readFixture(t, "details_paragraphs.html")))
+	te, err := Details(c, 205, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Erster Absatz mit Umlauten: Größe.\nZweite Zeile.\n\nZweiter Absatz.\n\nDritter Absatz."
+	if te.Description != want {
+		t.Errorf("Description = %q, want %q", te.Description, want)
+	}
+}
+
+func TestParseUploadError(t *testing.T) {
+	messages := []struct {
+		msg    string
+		reason error
+	}{
+		{"Dieser Torrent existiert bereits!", ErrDuplicate},
+		{"Ein Torrent mit diesem Info-Hash existiert bereits.", ErrDuplicate},
+		{"Dieses Release wurde bereits hochgeladen: Newest.Release.2018", ErrDuplicate},
+		{"Du musst eine NFO-Datei angeben!", ErrMissingNfo},
+		{"Es wurde keine NFO-Datei angegeben.", ErrMissingNfo},
+		{"Dieses Release ist gesperrt.", ErrBannedRelease},
+		{"Uploads dieser Gruppe sind nicht erlaubt!", ErrBannedRelease},
+		{"Du musst eine Kategorie auswählen!", ErrWrongCategory},
+		{"Ungültige Kategorie.", ErrWrongCategory},
+		// the words alone are no reason
+		{"Der Name enthält Zeichen, die nicht erlaubt sind.", ErrUploadUnknown},
+		{"Die Datei Kategorie.Dupe.Release.torrent ist zu groß.", ErrUploadUnknown},
+		{"Die NFO ist gesperrt für Sonderzeichen: keine NFO-Kunst bitte", ErrUploadUnknown},
+		{"", ErrUploadUnknown},
+	}
+	for _, m := range messages {
+		err := parseUploadError(m.msg)
+		if !errors.Is(err, m.reason) {
+			t.Errorf("parseUploadError(%q) = %v, want %v", m.msg, err, m.reason)
+		}
+		if ue, ok := err.(*UploadError); !ok || ue.Message != m.msg {
+			t.Errorf("parseUploadError(%q) = %#v, want the message in an UploadError", m.msg, err)
+		}
+	}
+}
+
+func TestDetailsOptionalRows(t *testing.T) {
+	// cover, pictures, owner, note, sticky, tags, announce and freeleech rows come before the files
+	c := newTestConnection(t, pageHandler(readFixture(t, "details_optional_rows.html")))
+
+	te, err := Details(c, 206, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if te.FileCount != 2 || te.Files != nil {
+		t.Errorf("got %d files %v, want 2 files without the list", te.FileCount, te.Files)
+	}
+	if te.SnatchCount != 4 || te.DownloadCount != 6 {
+		t.Errorf("got %d snatches and %d downloads, want 4 and 6", te.SnatchCount, te.DownloadCount)
+	}
+	if te.SeederCount != 2 || te.LeecherCount != 1 || te.PeerCount != 3 {
+		t.Errorf("got %d seeders, %d leechers and %d peers, want 2, 1 and 3", te.SeederCount, te.LeecherCount, te.PeerCount)
+	}
+
+	te, err = Details(c, 206, true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TorrentFile{{"Optional.Rows.2018.mkv", 2147479552}, {"Optional.Rows.2018.nfo", 4096}}
+	if fmt.Sprint(te.Files) != fmt.Sprint(want) || te.FileCount != 2 {
+		t.Errorf("got %d files %v, want %v", te.FileCount, te.Files, want)
+	}
+}
+
+func TestSearchDuplicates(t *testing.T) {
+	// the second page repeats 509 with more seeders and 508 with fewer leechers
+	pages := [][]byte{readFixture(t, "search_0.html"), readFixture(t, "search_shifted.html")}
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var page int
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(pages[page])
+	}))
+	entries, err := Search(c, "2018", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, 0, len(entries))
+	found := make(map[int]TorrentEntry)
+	for _, te := range entries {
+		ids = append(ids, te.Id)
+		found[te.Id] = te
+	}
+	sort.Ints(ids)
+	if want := []int{507, 508, 509, 510}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("got %v, want %v", ids, want)
+	}
+	if te := found[509]; te.SeederCount != 4 {
+		t.Errorf("509 has %d seeders, want the entry with 4", te.SeederCount)
+	}
+	if te := found[508]; te.SeederCount != 5 || te.LeecherCount != 2 {
+		t.Errorf("508 has %d seeders and %d leechers, want the entry with 5 and 2", te.SeederCount, te.LeecherCount)
+	}
+}
+
+func TestBetterTorrentEntry(t *testing.T) {
+	tests := []struct {
+		a, b TorrentEntry
+	}{
+		{TorrentEntry{SeederCount: 2}, TorrentEntry{SeederCount: 1, LeecherCount: 9}},
+		{TorrentEntry{LeecherCount: 2}, TorrentEntry{LeecherCount: 1, SnatchCount: 9}},
+		{TorrentEntry{SnatchCount: 2}, TorrentEntry{SnatchCount: 1, CommentCount: 9}},
+		{TorrentEntry{CommentCount: 2}, TorrentEntry{CommentCount: 1}},
+		{TorrentEntry{Name: "a"}, TorrentEntry{Name: "b"}},
+	}
+	for _, tt := range tests {
+		// the winner must not depend on the order the entries arrive in
+		if !betterTorrentEntry(tt.a, tt.b) || betterTorrentEntry(tt.b, tt.a) {
+			t.Errorf("%+v should win against %+v", tt.a, tt.b)
+		}
+	}
+}
+
+// Accept an upload and record the names and contents of the uploaded files by form field
+func uploadHandler(t *testing.T, files map[string][2]string) http.Handler {
+	done := readFixture(t, "upload_done.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/takeupload.php" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("upload is not a multipart form: %v", err)
+		}
+		for field, headers := range r.MultipartForm.File {
+			f, err := headers[0].Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := ioutil.ReadAll(f)
+			f.Close()
+			files[field] = [2]string{headers[0].Filename, string(content)}
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(done)
+	})
+}
+
+func TestUploadImages(t *testing.T) {
+	files := make(map[string][2]string)
+	c := newTestConnection(t, uploadHandler(t, files))
+
+	upload, _ := NewUpload(c, strings.NewReader("meta"), strings.NewReader("nfo"), nil, "Some.Release.2018", 17, "Beschreibung")
+	upload.Images = []io.Reader{strings.NewReader("one"), strings.NewReader("two"), strings.NewReader("three")}
+	upload.MaxImages = 3
+	if err := upload.Upload(); err != nil {
+		t.Fatal(err)
+	}
+	if upload.Id != 300 {
+		t.Errorf("Id = %d, want 300", upload.Id)
+	}
+
+	want := map[string][2]string{
+		"file": {"Some.Release.2018.torrent", "meta"},
+		"nfo":  {"Some.Release.2018.nfo", "nfo"},
+		"pic1": {"Some.Release.2018.jpg", "one"},
+		"pic2": {"Some.Release.2018_2.jpg", "two"},
+		"pic3": {"Some.Release.2018_3.jpg", "three"},
+	}
+	if fmt.Sprint(files) != fmt.Sprint(want) {
+		t.Errorf("uploaded files = %v, want %v", files, want)
+	}
+}
+
+func TestUploadDeprecatedImages(t *testing.T) {
+	files := make(map[string][2]string)
+	c := newTestConnection(t, uploadHandler(t, files))
+
+	upload, _ := NewUpload(c, strings.NewReader("meta"), strings.NewReader("nfo"), nil, "Some.Release.2018", 17, "")
+	upload.Image1 = strings.NewReader("one")
+	upload.Image2 = strings.NewReader("two")
+	if err := upload.Upload(); err != nil {
+		t.Fatal(err)
+	}
+	if files["pic1"][1] != "one" || files["pic2"][1] != "two" {
+		t.Errorf("pic1 = %q, pic2 = %q, want the two images", files["pic1"], files["pic2"])
+	}
+
+	upload.Images = []io.Reader{strings.NewReader("1"), strings.NewReader("2"), strings.NewReader("3")}
+	if err := upload.Upload(); err == nil {
+		t.Error("three images uploaded, the default maximum is two")
+	}
+}
+
+func TestDetailsPictures(t *testing.T) {
+	page := readFixture(t, "details_optional_rows.html")
+	cover := "<tr><td class=\"tableb\" width=\"150\">Cover</td><td class=\"tablea\"><img src=\"https://img.example/cover.jpg\"></td></tr>\n"
+	tests := []struct {
+		name   string
+		page   []byte
+		poster string
+		images []string
+	}{
+		{"cover row", page, "https://img.example/cover.jpg", []string{"https://img.example/shot1.jpg", "https://img.example/shot2.jpg"}},
+		// without a cover row the first picture is the poster
+		{"no cover row", bytes.Replace(page, []byte(cover), nil, 1), "https://img.example/shot1.jpg", []string{"https://img.example/shot2.jpg"}},
+		{"no pictures", readFixture(t, "details.html"), "", nil},
+	}
+	for _, tt := range tests {
+		c := newTestConnection(t, pageHandler(tt.page))
+		te, err := Details(c, 206, false, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if te.Poster != tt.poster || !reflect.DeepEqual(te.Images, tt.images) {
+			t.Errorf("%s: got poster %q and images %v, want %q and %v", tt.name, te.Poster, te.Images, tt.poster, tt.images)
+		}
+	}
+}
+
+func TestSearchLinklessCounts(t *testing.T) {
+	c := newTestConnection(t, pageHandler(readFixture(t, "browse_dead.html")))
+	entries, err := Search(c, "", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[int]TorrentEntry)
+	for _, te := range entries {
+		found[te.Id] = te
+	}
+	if len(found) != 2 {
+		t.Fatalf("got %d torrents, want 506 and the dead 505", len(found))
+	}
+	if te := found[506]; te.FileCount != 1 || te.SnatchCount != 40 || te.SeederCount != 20 || te.LeecherCount != 4 {
+		t.Errorf("506: got %d files, %d snatches, %d seeders and %d leechers, want 1, 40, 20 and 4", te.FileCount, te.SnatchCount, te.SeederCount, te.LeecherCount)
+	}
+	// plain numbers and empty cells instead of links
+	if te := found[505]; te.FileCount != 2 || te.SnatchCount != 0 || te.SeederCount != 0 || te.LeecherCount != 0 {
+		t.Errorf("505: got %d files, %d snatches, %d seeders and %d leechers, want 2 and zero counts", te.FileCount, te.SnatchCount, te.SeederCount, te.LeecherCount)
+	}
+}
+
+func TestParseFileListExactSize(t *testing.T) {
+	table := `<table><tr><td>Datei</td><td>Größe</td></tr>
+<tr><td>release.mkv</td><td title="1.471.026.176 Bytes">1,37 GB</td></tr>
+<tr><td>release.nfo</td><td>1,37 GB</td></tr></table>`
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := parseFileList(doc.Find("table"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := "d5:filesld6:lengthi1471026176e4:pathl11:release.mkveed6:lengthi1471026176e4:pathl11:release.nfoee" +
+		"e4:name7:release12:piece lengthi16384e6:pieces0:e"
+	meta, err := ParseTorrentMeta([]byte("d4:info" + info + "e"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != meta.Files[0] {
+		t.Errorf("files = %+v, want the exact size %+v from the title", files, meta.Files[0])
+	}
+	// without the title the shown size is all there is, rounded to 10 MB
+	if len(files) == 2 && files[1].Size == meta.Files[1].Size {
+		t.Errorf("size %d without a title equals the exact size", files[1].Size)
+	}
+	if len(files) == 2 && files[1].Size != stringToDatasize("1,37 GB") {
+		t.Errorf("size = %d, want the shown size %d", files[1].Size, stringToDatasize("1,37 GB"))
+	}
+}
+
+// Serve the browse fixture and the details fixture for torrent 205, other details pages are broken
+func detailsByInfoHashHandler(t *testing.T, requests *int32) http.Handler {
+	browse := readFixture(t, "browse.html")
+	details := readFixture(t, "details.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		switch r.URL.Path {
+		case "/browse.php":
+			w.Write(browse)
+		case "/details.php":
+			atomic.AddInt32(requests, 1)
+			if r.URL.Query().Get("id") != "205" {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("<html><body>Datenbankfehler</body></html>"))
+				return
+			}
+			w.Write(details)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func TestDetailsByInfoHash(t *testing.T) {
+	var requests int32
+	c := newTestConnection(t, detailsByInfoHashHandler(t, &requests))
+	c.SetMaxConcurrency(1)
+
+	te, err := DetailsByInfoHash(c, "0123456789ABCDEF0123456789ABCDEF01234567")
+	if err != nil || te.Id != 205 {
+		t.Fatalf("DetailsByInfoHash = %v, %v, want torrent 205", te, err)
+	}
+	// 101 is listed before 205, 204 must not be fetched anymore
+	if requests != 2 {
+		t.Errorf("fetched %d details pages, want 2", requests)
+	}
+}
+
+func TestDetailsByInfoHashErrors(t *testing.T) {
+	var requests int32
+	c := newTestConnection(t, detailsByInfoHashHandler(t, &requests))
+
+	_, err := DetailsByInfoHash(c, "ffffffffffffffffffffffffffffffffffffffff")
+	if err == nil || err == ErrTorrentNotFound {
+		t.Errorf("DetailsByInfoHash = %v, want the error of the broken details pages", err)
+	}
+	if requests != 3 {
+		t.Errorf("fetched %d details pages, want 3", requests)
+	}
+}
+
+func TestDetailsLatin1(t *testing.T) {
+	location := berlin(t)
+	c := newTestConnection(t, pageHandler(readFixture(t, "details.html")))
+	c.SetLocation(location)
+	te, err := Details(c, 205, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if te.Id != 205 || te.Name != "Newest.Release.2018" {
+		t.Errorf("got torrent %d %q, want 205 Newest.Release.2018", te.Id, te.Name)
+	}
+	if te.InfoHash != "0123456789abcdef0123456789abcdef01234567" {
+		t.Errorf("InfoHash = %q", te.InfoHash)
+	}
+	if te.Size != 1610612736 {
+		t.Errorf("Size = %d, want 1610612736", te.Size)
+	}
+	if te.SnatchCount != 7 || te.DownloadCount != 9 {
+		t.Errorf("got %d snatches and %d downloads, want 7 and 9", te.SnatchCount, te.DownloadCount)
+	}
+	if want := time.Date(2018, 3, 12, 20, 15, 0, 0, location); !te.Added.Equal(want) {
+		t.Errorf("Added = %v, want %v", te.Added, want)
+	}
+	if want := time.Date(2018, 3, 13, 9, 0, 0, 0, location); !te.LastEdited.Equal(want) {
+		t.Errorf("LastEdited = %v, want %v", te.LastEdited, want)
+	}
+	if want := "Eine Beschreibung mit \u0081 und \u00ff."; !bytes.Contains([]byte(te.Description), []byte(want)) {
+		t.Errorf("Description = %q, want it to contain %q", te.Description, want)
+	}
+}
+
+func TestSnatchesExcludeSelf(t *testing.T) {
+	pages := map[string][]byte{
+		"/details.php":      readFixture(t, "details_thanked.html"),
+		"/viewsnatches.php": readFixture(t, "viewsnatches_own.html"),
+	}
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(page)
+	}))
+	names := func(snatches []Snatch) string {
+		list := make([]string, 0, len(snatches))
+		for _, snatch := range snatches {
+			list = append(list, snatch.Name)
+		}
+		sort.Strings(list)
+		return fmt.Sprint(list)
+	}
+
+	// the list contains the snatch of user 42
+	snatches, err := Snatches(c, 205, SnatchesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(snatches); got != "[alice user]" {
+		t.Errorf("got the snatches of %s, want alice and user", got)
+	}
+
+	snatches, err = Snatches(c, 205, SnatchesOptions{ExcludeSelf: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(snatches); got != "[alice]" {
+		t.Errorf("got the snatches of %s, want only alice", got)
+	}
+
+	entries, errs := DetailsMany(context.Background(), c, []int64{205}, DetailsOptions{Snatches: true, SnatchesOptions: SnatchesOptions{ExcludeSelf: true}})
+	if errs[205] != nil {
+		t.Fatal(errs[205])
+	}
+	if got := names(entries[205].Snatches); got != "[alice]" {
+		t.Errorf("details: got the snatches of %s, want only alice", got)
+	}
+}
+
+func TestDetailsDownloadCountFallback(t *testing.T) {
+	// older pages have no download row, the snatch count is used instead
+	row := "<tr><td class=\"tableb\" width=\"150\">Heruntergeladen</td><td class=\"tablea\">9 mal</td></tr>\n"
+	page := bytes.Replace(readFixture(t, "details.html"), []byte(row), nil, 1)
+	c := newTestConnection(t, pageHandler(page))
+	te, err := Details(c, 205, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if te.SnatchCount != 7 || te.DownloadCount != 7 {
+		t.Errorf("got %d snatches and %d downloads, want 7 and 7", te.SnatchCount, te.DownloadCount)
+	}
+}
+
+func TestThankList(t *testing.T) {
+	tests := []struct {
+		fixture string
+		users   []UserRef
+	}{
+		{"details_thanked.html", []UserRef{{"alice", 10}, {"user", 42}}},
+		{"details.html", []UserRef{}},
+	}
+	for _, tt := range tests {
+		c := newTestConnection(t, pageHandler(readFixture(t, tt.fixture)))
+		users, err := ThankList(c, 205)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if users == nil || !reflect.DeepEqual(users, tt.users) {
+			t.Errorf("%s: got %#v, want %#v", tt.fixture, users, tt.users)
+		}
+	}
+}
+
+func TestParsePeerSummary(t *testing.T) {
+	tests := []struct {
+		summary                string
+		seeders, leechers, all int
+	}{
+		{"2 Seeder, 1 Leecher = 3 Peer(s) gesamt", 2, 1, 3},
+		{"1 Seeder, 0 Leecher = 1 Peer gesamt", 1, 0, 1},
+		{"0 Seeder, 0 Leecher = 0 Peer(s) gesamt", 0, 0, 0},
+		// without a total the counts are added up
+		{"5 Seeder, 2 Leecher", 5, 2, 7},
+		{"3 seeder", 3, 0, 3},
+		{"keine Peers", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		te := TorrentEntry{}
+		parsePeerSummary(tt.summary, &te)
+		if te.SeederCount != tt.seeders || te.LeecherCount != tt.leechers || te.PeerCount != tt.all {
+			t.Errorf("%q: got %d/%d/%d, want %d/%d/%d", tt.summary, te.SeederCount, te.LeecherCount, te.PeerCount, tt.seeders, tt.leechers, tt.all)
+		}
+	}
+}
+
+func TestDetailsFreeleech(t *testing.T) {
+	now := time.Date(2018, 3, 14, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		fixture   string
+		freeleech bool
+		until     time.Time
+	}{
+		{"details.html", false, time.Time{}},
+		{"details_freeleech.html", true, now.Add(53 * time.Hour)},
+		// only the freeleech row counts, not the description
+		{"details_description.html", false, time.Time{}},
+	}
+	for _, test := range tests {
+		c := newTestConnection(t, pageHandler(readFixture(t, test.fixture)))
+		c.SetLocation(time.UTC)
+		c.clock = func() time.Time { return now }
+
+		te, err := Details(c, 205, false, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if te.Freeleech != test.freeleech || !te.FreeleechUntil.Equal(test.until) {
+			t.Errorf("%s: Freeleech = %v until %v, want %v until %v", test.fixture, te.Freeleech, te.FreeleechUntil, test.freeleech, test.until)
+		}
+	}
+}
+
+func TestDetailsPeers(t *testing.T) {
+	// the peer lists follow the optional rows and the file list
+	c := newTestConnection(t, pageHandler(readFixture(t, "details_peers.html")))
+
+	for _, files := range []bool{false, true} {
+		te, err := Details(c, 206, files, true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if te.SeederCount != 2 || te.LeecherCount != 1 || te.PeerCount != 3 {
+			t.Errorf("files %v: got %d seeders, %d leechers and %d peers, want 2, 1 and 3", files, te.SeederCount, te.LeecherCount, te.PeerCount)
+		}
+		names := make([]string, 0)
+		for _, peer := range te.Peers {
+			names = append(names, peer.Name)
+		}
+		if fmt.Sprint(names) != "[alice carol dave]" {
+			t.Errorf("files %v: got peers %v, want [alice carol dave]", files, names)
+		}
+		if len(te.Peers) == 3 {
+			alice, dave := te.Peers[0], te.Peers[2]
+			if alice.UserId != 10 || !alice.Seeder || alice.Uploaded != 1610612736 || alice.Client != "qBittorrent 4.0.3" {
+				t.Errorf("files %v: unexpected seeder %+v", files, alice)
+			}
+			if dave.Seeder || dave.Completed != 50 || dave.Dlrate != 2097152 || dave.Connected != 3600 {
+				t.Errorf("files %v: unexpected leecher %+v", files, dave)
+			}
+		}
+	}
+}
+
+// Serve the two pages of the search fixture
+func searchHandler(t *testing.T) http.Handler {
+	pages := [][]byte{readFixture(t, "search_0.html"), readFixture(t, "search_1.html")}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var page int
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		if r.URL.Path != "/browse.php" || page >= len(pages) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(pages[page])
+	})
+}
+
+func searchIds(t *testing.T, c *Connection, opts SearchOptions) []int {
+	entries, err := SearchWithOptions(c, "2018", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, 0, len(entries))
+	for _, te := range entries {
+		ids = append(ids, te.Id)
+	}
+
+	return ids
+}
+
+func TestSearchMinSeeders(t *testing.T) {
+	c := newTestConnection(t, searchHandler(t))
+
+	// the crawled pages are merged in no particular order
+	ids := searchIds(t, c, SearchOptions{MinSeeders: 5})
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	if fmt.Sprint(ids) != "[510 508 506]" {
+		t.Errorf("got torrents %v, want [510 508 506]", ids)
+	}
+}
+
+func TestSearchStableOrder(t *testing.T) {
+	c := newTestConnection(t, searchHandler(t))
+
+	// 509 and 508 were added at the same time, the higher id comes first
+	first := searchIds(t, c, SearchOptions{})
+	if fmt.Sprint(first) != "[510 509 508 507 506]" {
+		t.Errorf("got torrents %v, want the newest first", first)
+	}
+	for i := 0; i < 5; i++ {
+		if ids := searchIds(t, c, SearchOptions{}); fmt.Sprint(ids) != fmt.Sprint(first) {
+			t.Fatalf("search %d returned %v, the first one %v", i+2, ids, first)
+		}
+	}
+
+	if ids := searchIds(t, c, SearchOptions{Sort: SortSeeders, Ascending: true}); fmt.Sprint(ids) != "[507 509 508 510 506]" {
+		t.Errorf("sorted by seeders: got torrents %v", ids)
+	}
+}
+
+func TestDetailsAnnounce(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	DEBUG = true
+	defer func() {
+		DEBUG = false
+		log.SetOutput(os.Stderr)
+	}()
+	// the fixture has only two lines, so the announce row is part of the (three line) debug output
+	c := newTestConnection(t, pageHandler(readFixture(t, "details_announce.html")))
+
+	te, err := Details(c, 205, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://tracker.example/0123456789abcdef0123456789abcdef/announce"; te.Announce != want {
+		t.Errorf("Announce = %q, want %q", te.Announce, want)
+	}
+	if !strings.Contains(output.String(), "https://tracker.example/[redacted]/announce") {
+		t.Errorf("debug output does not contain the redacted announce url:\n%s", output.String())
+	}
+	if strings.Contains(output.String(), "0123456789abcdef0123456789abcdef") {
+		t.Errorf("debug output contains the passkey:\n%s", output.String())
+	}
+
+	// the announce row is optional
+	c = newTestConnection(t, pageHandler(readFixture(t, "details.html")))
+	if te, err := Details(c, 205, false, false, false); err != nil || te.Announce != "" {
+		t.Errorf("Details = %q, %v without the row, want no announce url", te.Announce, err)
+	}
+}
+
+func TestSearchFirst(t *testing.T) {
+	tests := []struct {
+		n        int
+		ids      []int
+		requests int
+	}{
+		{0, []int{}, 0},
+		{2, []int{510, 509}, 1},
+		{3, []int{510, 509, 508}, 1},
+		{4, []int{510, 509, 508, 507}, 2},
+		{10, []int{510, 509, 508, 507, 506}, 2},
+	}
+	for _, tt := range tests {
+		requests := 0
+		handler := searchHandler(t)
+		c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			handler.ServeHTTP(w, r)
+		}))
+		entries, err := SearchFirst(c, "2018", nil, tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]int, 0, len(entries))
+		for _, te := range entries {
+			ids = append(ids, te.Id)
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("n=%d: got %v, want %v", tt.n, ids, tt.ids)
+		}
+		if requests != tt.requests {
+			t.Errorf("n=%d: %d requests, want %d", tt.n, requests, tt.requests)
+		}
+	}
+}
+
+func TestWalkCategory(t *testing.T) {
+	var mutex sync.Mutex
+	pages := make([]string, 0)
+	search := searchHandler(t)
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		pages = append(pages, r.URL.Query().Get("cat")+"/"+r.URL.Query().Get("page"))
+		mutex.Unlock()
+		search.ServeHTTP(w, r)
+	}))
+
+	walk := func(limit int) ([]int, error) {
+		ids := make([]int, 0)
+		err := WalkCategory(context.Background(), c, 17, func(te TorrentEntry) error {
+			if len(ids) == limit {
+				return StopWalk
+			}
+			ids = append(ids, te.Id)
+			return nil
+		})
+		return ids, err
+	}
+
+	ids, err := walk(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != "[510 509 508 507 506]" || fmt.Sprint(pages) != "[17/ 17/1]" {
+		t.Errorf("walked %v over the pages %v, want all torrents of both pages of category 17", ids, pages)
+	}
+
+	pages = pages[:0]
+	ids, err = walk(2)
+	if err != nil || fmt.Sprint(ids) != "[510 509]" || len(pages) != 1 {
+		t.Errorf("StopWalk: walked %v over %d pages (%v), want the first two torrents of one page", ids, len(pages), err)
+	}
+
+	failed := errors.New("disk full")
+	err = WalkCategory(context.Background(), c, 17, func(te TorrentEntry) error {
+		return failed
+	})
+	if err != failed {
+		t.Errorf("err = %v, want the error of the callback", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := WalkCategory(ctx, c, 17, func(te TorrentEntry) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestDetailsNfo(t *testing.T) {
+	details := readFixture(t, "details.html")
+	nfo := readFixture(t, "viewnfo.html")
+	nfoRequests := 0
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		switch r.URL.Path {
+		case "/details.php":
+			w.Write(details)
+		case "/viewnfo.php":
+			nfoRequests++
+			if r.URL.Query().Get("id") != "205" {
+				// torrents without an NFO
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(nfo)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	tests := []struct {
+		id   int64
+		opts DetailsOptions
+		nfo  string
+	}{
+		{205, DetailsOptions{Nfo: true}, "  ÛÛ°° Some.Movie.2018 °°ÛÛ\n  Grüße <aus> dem Irrenhaus"},
+		{300, DetailsOptions{Nfo: true}, ""},
+		{205, DetailsOptions{}, ""},
+	}
+	for _, tt := range tests {
+		nfoRequests = 0
+		entries, errs := DetailsMany(context.Background(), c, []int64{tt.id}, tt.opts)
+		if errs[tt.id] != nil {
+			t.Fatal(errs[tt.id])
+		}
+		if te := entries[tt.id]; te.Nfo != tt.nfo {
+			t.Errorf("%d: Nfo = %q, want %q", tt.id, te.Nfo, tt.nfo)
+		}
+		if want := map[bool]int{true: 1, false: 0}[tt.opts.Nfo]; nfoRequests != want {
+			t.Errorf("%d: %d NFO requests, want %d", tt.id, nfoRequests, want)
+		}
+	}
+}
+
+func TestByteSizeAccessors(t *testing.T) {
+	c := newTestConnection(t, pageHandler(readFixture(t, "details.html")))
+	te, err := Details(c, 205, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if te.SizeBytes() != 1536*datasize.MB || te.SizeBytes().Bytes() != te.Size {
+		t.Errorf("SizeBytes = %v, want 1.5 GB (%d bytes)", te.SizeBytes(), te.Size)
+	}
+
+	f := TorrentFile{Size: 4096}
+	p := Peer{Uploaded: 2 * 1024 * 1024, Downloaded: 1024}
+	s := Snatch{Uploaded: 750 * 1024 * 1024, Downloaded: 3 * 1024 * 1024 * 1024}
+	tests := []struct {
+		name  string
+		bytes datasize.ByteSize
+		raw   uint64
+		want  datasize.ByteSize
+	}{
+		{"TorrentFile.SizeBytes", f.SizeBytes(), f.Size, 4 * datasize.KB},
+		{"Peer.UploadedBytes", p.UploadedBytes(), p.Uploaded, 2 * datasize.MB},
+		{"Peer.DownloadedBytes", p.DownloadedBytes(), p.Downloaded, datasize.KB},
+		{"Snatch.UploadedBytes", s.UploadedBytes(), s.Uploaded, 750 * datasize.MB},
+		{"Snatch.DownloadedBytes", s.DownloadedBytes(), s.Downloaded, 3 * datasize.GB},
+	}
+	for _, tt := range tests {
+		if tt.bytes != tt.want || tt.bytes.Bytes() != tt.raw {
+			t.Errorf("%s = %v, want %v (%d bytes)", tt.name, tt.bytes, tt.want, tt.raw)
+		}
+	}
+}
+
+func TestSearchEmptyNeedle(t *testing.T) {
+	var mutex sync.Mutex
+	queries := make([]url.Values, 0)
+	search := searchHandler(t)
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		queries = append(queries, r.URL.Query())
+		mutex.Unlock()
+		search.ServeHTTP(w, r)
+	}))
+
+	for _, needle := range []string{"", "  \t "} {
+		queries = queries[:0]
+		entries, err := Search(c, needle, []int{17}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// blank needles list all torrents like browsing
+		if len(entries) != 5 {
+			t.Errorf("Search(%q) returned %d torrents, want all 5", needle, len(entries))
+		}
+		for _, query := range queries {
+			if _, ok := query["search"]; ok {
+				t.Errorf("Search(%q) sent search=%q", needle, query.Get("search"))
+			}
+			if query.Get("cat") != "17" {
+				t.Errorf("Search(%q) sent cat=%q, want 17", needle, query.Get("cat"))
+			}
+		}
+	}
+
+	if values := searchValues("  Alpha  ", nil, false); values.Get("search") != "Alpha" {
+		t.Errorf("search = %q, want the trimmed needle", values.Get("search"))
+	}
+}
+
+func TestSnatchesLastActive(t *testing.T) {
+	location := berlin(t)
+	c := newTestConnection(t, pageHandler(readFixture(t, "viewsnatches.html")))
+	c.SetLocation(location)
+
+	snatches, err := Snatches(c, 205, SnatchesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]Snatch)
+	for _, snatch := range snatches {
+		found[snatch.Name] = snatch
+	}
+
+	alice := found["alice"]
+	if !alice.Seeding || !alice.LastActive.Equal(time.Date(2018, 3, 25, 3, 30, 0, 0, location)) {
+		t.Errorf("alice: Seeding = %v, LastActive = %v", alice.Seeding, alice.LastActive)
+	}
+	if want := time.Date(2018, 3, 12, 21, 0, 0, 0, location); !alice.Completed.Equal(want) {
+		t.Errorf("alice: Completed = %v, want %v", alice.Completed, want)
+	}
+	// without a last announce the stop time is used
+	bob := found["bob"]
+	want := time.Date(2018, 3, 20, 10, 0, 0, 0, location)
+	if bob.Seeding || !bob.Stopped.Equal(want) || !bob.LastActive.Equal(want) {
+		t.Errorf("bob: Seeding = %v, Stopped = %v, LastActive = %v, want %v", bob.Seeding, bob.Stopped, bob.LastActive, want)
+	}
+}
+
+func TestDetailsMediaInfo(t *testing.T) {
+	pages := map[string][]byte{"300": readFixture(t, "details_mediainfo.html"), "205": readFixture(t, "details.html")}
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Query().Get("id")]
+		if r.URL.Path != "/details.php" || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(page)
+	}))
+
+	entries, errs := DetailsMany(context.Background(), c, []int64{300, 205}, DetailsOptions{MediaInfo: true})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	want := MediaInfo{Resolution: "1080p", Source: "BluRay", VideoCodec: "AVC", AudioCodec: "DTS", Runtime: "2 h 5 min"}
+	if info := entries[300].MediaInfo; info == nil || *info != want {
+		t.Errorf("MediaInfo = %+v, want %+v", info, want)
+	}
+	if info := entries[205].MediaInfo; info != nil {
+		t.Errorf("MediaInfo = %+v without a mediainfo block, want nil", info)
+	}
+
+	entries, _ = DetailsMany(context.Background(), c, []int64{300}, DetailsOptions{})
+	if entries[300].MediaInfo != nil {
+		t.Error("MediaInfo parsed without DetailsOptions.MediaInfo")
+	}
+}
+
+func TestSnatchesTableClasses(t *testing.T) {
+	for _, fixture := range []string{"viewsnatches.html", "viewsnatches_tableb.html", "viewsnatches_tablea.html"} {
+		c := newTestConnection(t, pageHandler(readFixture(t, fixture)))
+		snatches, err := Snatches(c, 205, SnatchesOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0)
+		for _, snatch := range snatches {
+			names = append(names, snatch.Name)
+		}
+		sort.Strings(names)
+		if fmt.Sprint(names) != "[alice bob]" {
+			t.Errorf("%s: got the snatches of %v, want alice and bob", fixture, names)
+		}
+	}
+}
+
+func TestDetailsInfoHash(t *testing.T) {
+	tests := map[string]string{
+		"details.html":                "0123456789abcdef0123456789abcdef01234567",
+		"details_hash_spaced.html":    "0123456789abcdef0123456789abcdef01234567",
+		"details_hash_base32.html":    "0123456789abcdef0123456789abcdef01234567",
+		"details_hash_malformed.html": "",
+		"details_hash_empty.html":     "",
+	}
+	for fixture, want := range tests {
+		c := newTestConnection(t, pageHandler(readFixture(t, fixture)))
+		te, err := Details(c, 205, false, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if te.InfoHash != want {
+			t.Errorf("%s: InfoHash = %q, want %q", fixture, te.InfoHash, want)
+		}
+	}
+}
+
+func TestNewTorrents(t *testing.T) {
+	var mutex sync.Mutex
+	pages := make([]string, 0)
+	search := searchHandler(t)
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		pages = append(pages, r.URL.Query().Get("page"))
+		mutex.Unlock()
+		search.ServeHTTP(w, r)
+	}))
+
+	for limit, want := range map[int]string{0: "[510 509 508]", 2: "[510 509]", 10: "[510 509 508]"} {
+		entries, err := NewTorrents(c, []int{17, 18}, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]int, 0)
+		for _, te := range entries {
+			ids = append(ids, te.Id)
+		}
+		if fmt.Sprint(ids) != want {
+			t.Errorf("limit %d: got torrents %v, want %s", limit, ids, want)
+		}
+	}
+	for _, page := range pages {
+		if page != "" {
+			t.Errorf("page %s requested, want only the first page", page)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewTorrentsContext(ctx, c, nil, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestEffectiveDownloadBytes(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		entry TorrentEntry
+		want  uint64
+	}{
+		{"no freeleech", TorrentEntry{Size: 1610612736}, 1610612736},
+		{"permanent freeleech", TorrentEntry{Size: 1610612736, Freeleech: true}, 0},
+		{"freeleech running", TorrentEntry{Size: 1610612736, Freeleech: true, FreeleechUntil: now.Add(time.Hour)}, 0},
+		{"freeleech expired", TorrentEntry{Size: 1610612736, Freeleech: true, FreeleechUntil: now.Add(-time.Hour)}, 1610612736},
+		// an end date without the freeleech flag is ignored
+		{"end without freeleech", TorrentEntry{Size: 1610612736, FreeleechUntil: now.Add(time.Hour)}, 1610612736},
+		{"empty torrent", TorrentEntry{}, 0},
+	}
+	for _, test := range tests {
+		if got := EffectiveDownloadBytes(&test.entry); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+	}
+
+	// the site may label the row OnlyUpload
+	page := bytes.Replace(readFixture(t, "details.html"), []byte(">Freeleech</td><td class=\"tablea\">Nein<"), []byte(">OnlyUpload</td><td class=\"tablea\">Ja<"), 1)
+	c := newTestConnection(t, pageHandler(page))
+	te, err := Details(c, 205, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := EffectiveDownloadBytes(te); got != 0 || te.Size != 1610612736 {
+		t.Errorf("OnlyUpload: got %d of %d bytes, want 0", got, te.Size)
+	}
+}
+
+func TestMyTorrentActivity(t *testing.T) {
+	pages := map[string][]byte{
+		"/details.php?205":      readFixture(t, "details_thanked.html"),
+		"/details.php?300":      readFixture(t, "details.html"),
+		"/viewsnatches.php?205": readFixture(t, "viewsnatches_own.html"),
+		"/viewsnatches.php?300": readFixture(t, "viewsnatches.html"),
+		"/bookmarks.php?":       readFixture(t, "bookmarks_0.html"),
+		"/bookmarks.php?1":      readFixture(t, "bookmarks_1.html"),
+	}
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path + "?" + r.URL.Query().Get("id") + r.URL.Query().Get("page")
+		page, ok := pages[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(page)
+	}))
+
+	// snatched, thanked and bookmarked by user 42, but not in the peer list
+	activity, err := MyTorrentActivity(c, 205)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TorrentActivity{Snatched: true, Thanked: true, Bookmarked: true, MyUploaded: 750 * 1024 * 1024, MyDownloaded: 1536 * 1024 * 1024}
+	if *activity != want {
+		t.Errorf("activity = %+v, want %+v", *activity, want)
+	}
+
+	activity, err = MyTorrentActivity(c, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *activity != (TorrentActivity{}) {
+		t.Errorf("activity = %+v, want no activity", *activity)
+	}
+}
+
+func TestSearchCancelNoLeak(t *testing.T) {
+	page := readFixture(t, "search_crawl.html")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var crawled int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// cancel while the crawlers of the other pages are still running
+		if r.URL.Query().Get("page") != "" && atomic.AddInt32(&crawled, 1) == 3 {
+			cancel()
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(page)
+	})
+	before := runtime.NumGoroutine()
+	c := newTestConnection(t, handler)
+
+	if _, err := SearchContext(ctx, c, "2018", SearchOptions{}); err != context.Canceled {
+		t.Fatalf("SearchContext = %v, want %v", err, context.Canceled)
+	}
+
+	// the crawlers and connections wind down in the background
+	c.client.CloseIdleConnections()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before+1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		c.client.CloseIdleConnections()
+	}
+	// the test server keeps its accept loop
+	if n := runtime.NumGoroutine(); n > before+1 {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines left after canceling the search, %d before:\n%s", n, before, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestCategoryCounts(t *testing.T) {
+	page := readFixture(t, "browse_categories.html")
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/browse.php" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(page)
+	}))
+
+	counts, err := CategoryCounts(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 20 has no count and 9 is only linked by a torrent of the list
+	want := map[int]int{7: 1234, 8: 87, 12: 0}
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("got counts %v, want %v", counts, want)
+	}
+}
+
+func TestDownloadNfo(t *testing.T) {
+	// cp437 art and line endings the decoding would change
+	raw := []byte("\xdb\xdb\xb0\xb0 Some.Movie.2018 \xb0\xb0\xdb\xdb\r\n\x00\x1b[0m\xfc\xdf\r\n")
+	page := readFixture(t, "viewnfo.html")
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/viewnfo.php" || r.URL.Query().Get("download") != "1" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("id") {
+		case "1":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", `attachment; filename="Some.Movie.2018.nfo"`)
+			w.Write(raw)
+		case "2":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write(raw)
+		case "3":
+			// no raw download, the page shows the nfo
+			w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+			w.Write(page)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	tests := []struct {
+		id       int64
+		data     []byte
+		filename string
+	}{
+		{1, raw, "Some.Movie.2018.nfo"},
+		{2, raw, "2.nfo"},
+		{3, []byte("  \xdb\xdb\xb0\xb0 Some.Movie.2018 \xb0\xb0\xdb\xdb\n  Gr\xfc\xdfe <aus> dem Irrenhaus"), "3.nfo"},
+	}
+	for _, test := range tests {
+		data, filename, err := DownloadNfo(c, test.id)
+		if err != nil {
+			t.Fatalf("%d: %v", test.id, err)
+		}
+		if !bytes.Equal(data, test.data) {
+			t.Errorf("%d: got %q, want %q", test.id, data, test.data)
+		}
+		if filename != test.filename {
+			t.Errorf("%d: got filename %q, want %q", test.id, filename, test.filename)
+		}
+	}
+
+	if _, _, err := DownloadNfo(c, 4); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestParseTorrentListSticky(t *testing.T) {
+	sticky := make(map[int]bool)
+	for _, te := range parseTorrentListFixture(t, "browse.html") {
+		sticky[te.Id] = te.Sticky
+	}
+
+	expected := map[int]bool{101: true, 205: false, 204: false}
+	for id, want := range expected {
+		if got, ok := sticky[id]; !ok || got != want {
+			t.Errorf("torrent %d: sticky = %v (found %v), want %v", id, got, ok, want)
+		}
+	}
+}
+
+func TestDetailsRaw(t *testing.T) {
+	c := newTestConnection(t, pageHandler(readFixture(t, "details.html")))
+
+	entries, errs := DetailsMany(context.Background(), c, []int64{205}, DetailsOptions{IncludeRaw: true})
+	if err := errs[205]; err != nil {
+		t.Fatal(err)
+	}
+	raw := entries[205].Raw
+	// Anmerkung and Tags are not modeled by TorrentEntry
+	for label, want := range map[string]string{
+		"Info Hash":   "0123456789ABCDEF0123456789ABCDEF01234567",
+		"Typ":         "Doku SD",
+		"Größe":       "1,50 GB (1,610,612,736 Bytes)",
+		"Hinzugefügt": "2018-03-12 20:15:00",
+		"Anmerkung":   "Bitte seeden!",
+		"Tags":        "Drama, Krimi",
+	} {
+		if raw[label] != want {
+			t.Errorf("Raw[%q] = %q, want %q", label, raw[label], want)
+		}
+	}
+
+	entries, errs = DetailsMany(context.Background(), c, []int64{205}, DetailsOptions{})
+	if err := errs[205]; err != nil {
+		t.Fatal(err)
+	}
+	if entries[205].Raw != nil {
+		t.Errorf("Raw = %v without IncludeRaw, want nil", entries[205].Raw)
+	}
+}
+
+func TestUploadFilenames(t *testing.T) {
+	files := make(map[string][2]string)
+	c := newTestConnection(t, uploadHandler(t, files))
+
+	upload, _ := NewUpload(c, strings.NewReader("meta"), strings.NewReader("nfo"), strings.NewReader("image"), "Some: Release/2018?", 17, "")
+	if err := upload.Upload(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][2]string{
+		"file": {"Some_ Release_2018_.torrent", "meta"},
+		"nfo":  {"Some_ Release_2018_.nfo", "nfo"},
+		"pic1": {"Some_ Release_2018_.jpg", "image"},
+	}
+	if fmt.Sprint(files) != fmt.Sprint(want) {
+		t.Errorf("uploaded files = %v, want %v", files, want)
+	}
+
+	for field := range files {
+		delete(files, field)
+	}
+	upload, _ = NewUpload(c, strings.NewReader("meta"), strings.NewReader("nfo"), strings.NewReader("image"), "Some.Release.2018", 17, "")
+	upload.MetaFilename = "Some.Release.2018-GRP.torrent"
+	upload.NfoFilename = "grp|info.nfo"
+	upload.ImageFilenames = []string{"cover.png"}
+	if err := upload.Upload(); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string][2]string{
+		"file": {"Some.Release.2018-GRP.torrent", "meta"},
+		"nfo":  {"grp_info.nfo", "nfo"},
+		"pic1": {"cover.png", "image"},
+	}
+	if fmt.Sprint(files) != fmt.Sprint(want) {
+		t.Errorf("uploaded files = %v, want %v", files, want)
+	}
+}
+
+func TestSearchByDateRange(t *testing.T) {
+	location := berlin(t)
+	var mutex sync.Mutex
+	requested := make(map[string]int)
+	c := newTestConnection(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		if page == "" {
+			page = "0"
+		}
+		mutex.Lock()
+		requested[page]++
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write(readFixture(t, fmt.Sprintf("browse_range_%s.html", page)))
+	}))
+	c.SetLocation(location)
+
+	from := time.Date(2018, 3, 10, 0, 0, 0, 0, location)
+	to := time.Date(2018, 3, 20, 23, 59, 59, 0, location)
+	entries, err := SearchByDateRange(c, nil, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]int, 0, len(entries))
+	for _, te := range entries {
+		ids = append(ids, te.Id)
+	}
+	if want := []int{411, 410, 409, 408}; fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Errorf("got torrents %v, want %v", ids, want)
+	}
+	if want := time.Date(2018, 3, 20, 23, 30, 0, 0, location); len(entries) > 0 && !entries[0].Added.Equal(want) {
+		t.Errorf("Added = %v, want %v", entries[0].Added, want)
+	}
+	if requested["2"] != 0 {
+		t.Errorf("fetched the last page although the range ended on the second page")
+	}
+}
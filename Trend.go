@@ -0,0 +1,102 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"sync"
+	"time"
+)
+
+// A seeder/leecher count of a torrent at a point in time
+type TrendSample struct {
+	Time     time.Time
+	Seeders  int
+	Leechers int
+}
+
+// Records the seeder and leecher counts of repeated Details calls of a torrent
+// and computes how they change. It is safe for concurrent use.
+type TrendTracker struct {
+	mutex   sync.Mutex
+	samples []TrendSample
+	// samples older than this (relative to the newest) are dropped, 0 keeps all
+	Window time.Duration
+}
+
+// Record the current counts of the torrent
+func (t *TrendTracker) Add(te *TorrentEntry) {
+	t.AddSample(TrendSample{Time: time.Now(), Seeders: te.SeederCount, Leechers: te.LeecherCount})
+}
+
+// Record a sample, samples have to be added in chronological order
+func (t *TrendTracker) AddSample(sample TrendSample) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.samples = append(t.samples, sample)
+	if t.Window > 0 {
+		oldest := sample.Time.Add(-t.Window)
+		i := 0
+		for i < len(t.samples)-1 && t.samples[i].Time.Before(oldest) {
+			i++
+		}
+		t.samples = t.samples[i:]
+	}
+}
+
+// Get the change of the counts between the oldest and the newest sample
+func (t *TrendTracker) Delta() (seeders, leechers int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.samples) < 2 {
+		return 0, 0
+	}
+	first, last := t.samples[0], t.samples[len(t.samples)-1]
+
+	return last.Seeders - first.Seeders, last.Leechers - first.Leechers
+}
+
+// Get the change of the counts per hour between the oldest and the newest sample.
+// Returns zero rates if there are less than two samples or no time passed between them.
+func (t *TrendTracker) Rate() (seedersPerHour, leechersPerHour float64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.samples) < 2 {
+		return 0, 0
+	}
+	first, last := t.samples[0], t.samples[len(t.samples)-1]
+	hours := last.Time.Sub(first.Time).Hours()
+	if hours <= 0 {
+		return 0, 0
+	}
+
+	return float64(last.Seeders-first.Seeders) / hours, float64(last.Leechers-first.Leechers) / hours
+}
+
+// Get a copy of the recorded samples
+func (t *TrendTracker) Samples() []TrendSample {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	samples := make([]TrendSample, len(t.samples))
+	copy(samples, t.samples)
+
+	return samples
+}
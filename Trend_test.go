@@ -0,0 +1,77 @@
+/*
+ * irrenhaus-api, API wrapper for irrenhaus.dyndns.dk
+ * Copyright (C) 2018  Daniel Müller
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>
+ */
+package irrenhaus_api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrendTracker(t *testing.T) {
+	start := time.Date(2018, 3, 12, 20, 0, 0, 0, time.UTC)
+	series := []TrendSample{
+		{start, 10, 4},
+		{start.Add(30 * time.Minute), 12, 6},
+		{start.Add(time.Hour), 15, 5},
+		{start.Add(2 * time.Hour), 16, 2},
+	}
+	tests := []struct {
+		window                    time.Duration
+		samples                   int
+		seeders, leechers         int
+		seedersRate, leechersRate float64
+	}{
+		{0, 4, 6, -2, 3, -1},
+		// only the samples of the last hour are kept
+		{time.Hour, 2, 1, -3, 1, -3},
+	}
+	for _, test := range tests {
+		tracker := TrendTracker{Window: test.window}
+		if s, l := tracker.Rate(); s != 0 || l != 0 {
+			t.Errorf("window %v: Rate = %v/%v without samples, want 0/0", test.window, s, l)
+		}
+		for _, sample := range series {
+			tracker.AddSample(sample)
+		}
+
+		if n := len(tracker.Samples()); n != test.samples {
+			t.Errorf("window %v: %d samples, want %d", test.window, n, test.samples)
+		}
+		if s, l := tracker.Delta(); s != test.seeders || l != test.leechers {
+			t.Errorf("window %v: Delta = %d/%d, want %d/%d", test.window, s, l, test.seeders, test.leechers)
+		}
+		if s, l := tracker.Rate(); s != test.seedersRate || l != test.leechersRate {
+			t.Errorf("window %v: Rate = %v/%v, want %v/%v", test.window, s, l, test.seedersRate, test.leechersRate)
+		}
+	}
+}
+
+func TestTrendTrackerAdd(t *testing.T) {
+	var tracker TrendTracker
+	tracker.Add(&TorrentEntry{SeederCount: 12, LeecherCount: 3})
+	tracker.Add(&TorrentEntry{SeederCount: 12, LeecherCount: 3})
+
+	samples := tracker.Samples()
+	if len(samples) != 2 || samples[0].Seeders != 12 || samples[0].Leechers != 3 || samples[0].Time.IsZero() {
+		t.Errorf("got samples %+v, want two of 12 seeders and 3 leechers", samples)
+	}
+	// the counts did not change
+	if s, l := tracker.Delta(); s != 0 || l != 0 {
+		t.Errorf("Delta = %d/%d, want 0/0", s, l)
+	}
+}